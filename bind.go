@@ -0,0 +1,159 @@
+package web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Bind decodes the request into v, which must be a pointer, according to
+// its Content-Type:
+//
+//	application/json                    encoding/json
+//	application/xml, text/xml           encoding/xml
+//	application/x-www-form-urlencoded   `form` struct tags
+//	multipart/form-data                 `form` struct tags
+//
+// Requests without a body are bound from the query string. The result is
+// then checked with Validate. Malformed input is reported with a status of
+// 400, unsupported content types with 415, and validation failures as
+// ValidationErrors, with 422.
+func (ctx *Context) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Bind requires a non-nil pointer, got %T", v)
+	}
+
+	req := ctx.Request
+	mediatype := ""
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		var err error
+		mediatype, _, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return &HTTPError{Code: 400, Message: "Malformed Content-Type: " + err.Error()}
+		}
+	}
+
+	var err error
+	switch {
+	case req.ContentLength == 0 || req.Body == nil:
+		if err = ctx.parseForm(); err == nil {
+			err = bindForm(rv, req.Form)
+		}
+	case mediatype == "application/json":
+		err = json.NewDecoder(req.Body).Decode(v)
+	case mediatype == "application/xml" || mediatype == "text/xml":
+		err = xml.NewDecoder(req.Body).Decode(v)
+	case mediatype == "application/x-www-form-urlencoded":
+		if err = ctx.parseForm(); err == nil {
+			err = bindForm(rv, req.Form)
+		}
+	case mediatype == "multipart/form-data":
+		if err = ctx.ParseForm(); err == nil {
+			err = bindForm(rv, req.Form)
+		}
+	default:
+		return &HTTPError{Code: 415, Message: "Unsupported Content-Type " + mediatype}
+	}
+	if err != nil {
+		var perr *ParamError
+		var herr *HTTPError
+		if errors.As(err, &perr) || errors.As(err, &herr) {
+			return err
+		}
+		return bodyError(err)
+	}
+	return Validate(v)
+}
+
+// bindForm sets the fields of the struct pointed to by rv from form values.
+// A field is bound to the value named by its `form` tag, or by the field
+// name; `form:"-"` skips it. Embedded structs are bound as if their fields
+// belonged to the outer struct.
+func bindForm(rv reflect.Value, form url.Values) error {
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot bind form values to %v", rv.Type())
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		fv := rv.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindForm(fv.Addr(), form); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := field.Name
+		if tag := field.Tag.Get("form"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = strings.Split(tag, ",")[0]
+		}
+		values, ok := form[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if err := setField(fv, values); err != nil {
+			if ne, ok := err.(*strconv.NumError); ok {
+				err = ne.Err
+			}
+			return &ParamError{Name: name, Value: values[0], Err: err}
+		}
+	}
+	return nil
+}
+
+// setField sets a struct field from form values. Slices take every value,
+// other fields the first one.
+func setField(fv reflect.Value, values []string) error {
+	t := fv.Type()
+	if t.Kind() == reflect.Slice && !convertible(t) {
+		elems := reflect.MakeSlice(t, 0, len(values))
+		for _, value := range values {
+			elem, err := convertValue(value, t.Elem())
+			if err != nil {
+				return err
+			}
+			elems = reflect.Append(elems, elem)
+		}
+		fv.Set(elems)
+		return nil
+	}
+	v, err := convertValue(values[0], t)
+	if err != nil {
+		return err
+	}
+	fv.Set(v)
+	return nil
+}
+
+// convertValue is like convertArg, but also allocates pointers to the
+// supported types.
+func convertValue(s string, t reflect.Type) (reflect.Value, error) {
+	if convertible(t) {
+		return convertArg(s, t)
+	}
+	if t.Kind() == reflect.Ptr && convertible(t.Elem()) {
+		v, err := convertArg(s, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported field type %v", t)
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// HTTPError is an error that carries the status code of the response it
+// should produce. Handlers can return one to fail with a status other than
+// 500; any error with a `StatusCode() int` method works the same way.
+type HTTPError struct {
+	Code    int
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Code)
+	}
+	return e.Message
+}
+
+// StatusCode returns the status code of the error.
+func (e *HTTPError) StatusCode() int {
+	return e.Code
+}
+
+// ErrorStatus returns the status code for err: the result of its
+// `StatusCode() int` method, or of the first error it wraps that has one,
+// and 500 otherwise. Codes outside of 100-599, such as that of a zero
+// HTTPError, are also reported as 500.
+func ErrorStatus(err error) int {
+	var sc interface {
+		StatusCode() int
+	}
+	if errors.As(err, &sc) {
+		if code := sc.StatusCode(); code >= 100 && code <= 599 {
+			return code
+		}
+	}
+	return 500
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// PanicError is passed to Server.ErrorHandler when a handler or middleware
+// panics and Config.RecoverPanic is set.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprint("Handler crashed with error ", e.Value)
+}
+
+// handleError responds to a request whose handler failed with err. Unless
+// an ErrorHandler is set, errors with a status code are shown to the client
+// as plain text and other errors are logged.
+func (s *Server) handleError(ctx *Context, err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(ctx, err)
+		return
+	}
+	status := ErrorStatus(err)
+	if status == 500 {
+		if _, ok := err.(*PanicError); !ok {
+			s.Logger.Println("Handler returned error", err)
+		}
+		ctx.Abort(500, "Server Error")
+		return
+	}
+	// the message can contain client input, so it mustn't be read as HTML
+	ctx.Text(status, err.Error())
+}
+
+// notFound responds to a request that didn't match any route.
+func (s *Server) notFound(ctx *Context) {
+	if s.NotFoundHandler != nil {
+		s.NotFoundHandler(ctx)
+		return
+	}
+	ctx.Abort(404, "Page not found")
+}
+
+// methodNotAllowed responds to a request whose path matches some routes, but
+// none for the request method.
+func (s *Server) methodNotAllowed(ctx *Context) {
+	if s.MethodNotAllowedHandler != nil {
+		s.MethodNotAllowedHandler(ctx)
+		return
+	}
+	ctx.Abort(405, "Method not allowed")
+}
@@ -37,25 +37,27 @@ var page = `
 
 func index() string { return page }
 
-func multipart(ctx *web.Context) string {
-	ctx.Request.ParseMultipartForm(10 * 1024 * 1024)
-	form := ctx.Request.MultipartForm
+func multipart(ctx *web.Context) (string, error) {
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		return "", err
+	}
 	var output bytes.Buffer
-	output.WriteString("<p>input1: " + form.Value["input1"][0] + "</p>")
-	output.WriteString("<p>input2: " + form.Value["input2"][0] + "</p>")
+	output.WriteString("<p>input1: " + ctx.Request.FormValue("input1") + "</p>")
+	output.WriteString("<p>input2: " + ctx.Request.FormValue("input2") + "</p>")
 
-	fileHeader := form.File["file"][0]
-	filename := fileHeader.Filename
 	file, err := fileHeader.Open()
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
+	defer file.Close()
 
-	output.WriteString("<p>file: " + filename + " " + Md5(file) + "</p>")
-	return output.String()
+	output.WriteString("<p>file: " + fileHeader.Filename + " " + Md5(file) + "</p>")
+	return output.String(), nil
 }
 
 func main() {
+	web.Config.MultipartMemory = 10 * 1024 * 1024
 	web.Get("/", index)
 	web.Post("/multipart", multipart)
 	web.Run("0.0.0.0:9999")
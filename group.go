@@ -0,0 +1,89 @@
+package web
+
+import (
+	"golang.org/x/net/websocket"
+	"net/http"
+)
+
+// RouteGroup is a set of routes that share a path prefix and middleware.
+// Groups are created with Server.Group and can be nested to any depth.
+type RouteGroup struct {
+	server     *Server
+	parent     *RouteGroup
+	prefix     string
+	middleware []Middleware
+}
+
+// Group returns a route group for server s whose routes are all prefixed
+// with `prefix`. The prefix is a literal path, except for named parameters
+// such as `:version`, which are passed to handlers like any other capture.
+func (s *Server) Group(prefix string) *RouteGroup {
+	return &RouteGroup{server: s, prefix: quotePrefix(prefix)}
+}
+
+// Group returns a nested group whose prefix is appended to the prefix of g.
+// Its routes run the middleware of g before their own.
+func (g *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{server: g.server, parent: g, prefix: g.prefix + quotePrefix(prefix)}
+}
+
+// Use adds middleware that runs for every route of the group, including the
+// routes of nested groups. It accepts the same forms of middleware as
+// Server.Use.
+func (g *RouteGroup) Use(middleware ...interface{}) {
+	for _, m := range middleware {
+		g.middleware = append(g.middleware, toMiddleware(m))
+	}
+}
+
+func (g *RouteGroup) addRoute(r string, method string, handler interface{}) *Route {
+	rt := g.server.addRoute(g.prefix+r, method, handler)
+	rt.group = g
+	return rt
+}
+
+// Get adds a handler for the 'GET' http method in group g.
+func (g *RouteGroup) Get(route string, handler interface{}) *Route {
+	return g.addRoute(route, "GET", handler)
+}
+
+// Post adds a handler for the 'POST' http method in group g.
+func (g *RouteGroup) Post(route string, handler interface{}) *Route {
+	return g.addRoute(route, "POST", handler)
+}
+
+// Put adds a handler for the 'PUT' http method in group g.
+func (g *RouteGroup) Put(route string, handler interface{}) *Route {
+	return g.addRoute(route, "PUT", handler)
+}
+
+// Delete adds a handler for the 'DELETE' http method in group g.
+func (g *RouteGroup) Delete(route string, handler interface{}) *Route {
+	return g.addRoute(route, "DELETE", handler)
+}
+
+// Match adds a handler for an arbitrary http method in group g.
+func (g *RouteGroup) Match(method string, route string, handler interface{}) *Route {
+	return g.addRoute(route, method, handler)
+}
+
+// Handle adds a custom http.Handler to group g. Will have no effect when running as FCGI or SCGI.
+func (g *RouteGroup) Handle(route string, method string, httpHandler http.Handler) *Route {
+	return g.addRoute(route, method, httpHandler)
+}
+
+// Websocket adds a handler for websockets to group g. Only for webserver mode.
+func (g *RouteGroup) Websocket(route string, httpHandler websocket.Handler) *Route {
+	return g.addRoute(route, "GET", httpHandler)
+}
+
+// chain returns the middleware of g and its parents, outermost first.
+func (g *RouteGroup) chain() []Middleware {
+	if g.parent == nil {
+		return g.middleware
+	}
+	parent := g.parent.chain()
+	chain := make([]Middleware, 0, len(parent)+len(g.middleware))
+	chain = append(chain, parent...)
+	return append(chain, g.middleware...)
+}
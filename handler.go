@@ -0,0 +1,363 @@
+package web
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// An invoker calls a route handler with the request context and the groups
+// captured from the path, and returns the value to write to the response
+// (nil for none) or the error the handler failed with.
+type invoker func(ctx *Context, captures []string) (interface{}, error)
+
+// compileInvoker returns an invoker for handler, which is passed the given
+// services after the captured groups. The most common handler signatures
+// are called directly, which avoids the cost of reflect.Call on every
+// request; anything else goes through reflection.
+func compileInvoker(handler reflect.Value, services []reflect.Value) invoker {
+	if !handler.CanInterface() || len(services) > 0 {
+		return reflectInvoker(handler, services)
+	}
+	switch h := handler.Interface().(type) {
+	case func():
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			h()
+			return nil, nil
+		}
+	case func() string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(), nil
+		}
+	case func() []byte:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(), nil
+		}
+	case func() error:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return nil, h()
+		}
+	case func() (string, error):
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return resultOrError(h())
+		}
+	case func(string) string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(captures[0]), nil
+		}
+	case func(string, string) string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(captures[0], captures[1]), nil
+		}
+	case func(*Context):
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			h(ctx)
+			return nil, nil
+		}
+	case func(*Context) string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(ctx), nil
+		}
+	case func(*Context) error:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return nil, h(ctx)
+		}
+	case func(*Context) (string, error):
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return resultOrError(h(ctx))
+		}
+	case func(*Context, string):
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			h(ctx, captures[0])
+			return nil, nil
+		}
+	case func(*Context, string) string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(ctx, captures[0]), nil
+		}
+	case func(*Context, string, string) string:
+		return func(ctx *Context, captures []string) (interface{}, error) {
+			return h(ctx, captures[0], captures[1]), nil
+		}
+	}
+	return reflectInvoker(handler, services)
+}
+
+func resultOrError(s string, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reflectInvoker returns an invoker that calls handler through reflection,
+// converting the captured groups to the types of its parameters.
+func reflectInvoker(handler reflect.Value, services []reflect.Value) invoker {
+	handlerType := handler.Type()
+	withContext := requiresContext(handlerType)
+	// whether the last result is an error
+	withError := handlerType.NumOut() > 0 && handlerType.Out(handlerType.NumOut()-1) == errorType
+
+	return func(ctx *Context, captures []string) (interface{}, error) {
+		args := make([]reflect.Value, 0, len(captures)+len(services)+1)
+		if withContext {
+			args = append(args, reflect.ValueOf(ctx))
+		}
+		for _, arg := range captures {
+			t := argType(handlerType, len(args))
+			if t.Kind() == reflect.String {
+				args = append(args, reflect.ValueOf(arg).Convert(t))
+				continue
+			}
+			v, err := convertArg(arg, t)
+			if err != nil {
+				return nil, argError(arg, err)
+			}
+			args = append(args, v)
+		}
+		args = append(args, services...)
+
+		ret := handler.Call(args)
+		if len(ret) == 0 {
+			return nil, nil
+		}
+		// a trailing error result takes precedence over the value
+		if withError {
+			if err, _ := ret[len(ret)-1].Interface().(error); err != nil {
+				return nil, err
+			}
+			if len(ret) == 1 {
+				return nil, nil
+			}
+		}
+		return ret[0].Interface(), nil
+	}
+}
+
+// callHandler invokes the handler of route with the captured arguments and
+// writes its return value to the response.
+func (s *Server) callHandler(ctx *Context, route *Route, captures []string) {
+	// set the default content-type
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+
+	result, err := route.invoke(ctx, captures)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	var content []byte
+	switch v := result.(type) {
+	case nil:
+		return
+	case string:
+		content = []byte(v)
+	case []byte:
+		content = v
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.String {
+			content = []byte(rv.String())
+		} else if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			content = rv.Bytes()
+		} else if serializable(rv.Kind()) {
+			data, ctype, err := ctx.serialize(v)
+			if err != nil {
+				s.handleError(ctx, err)
+				return
+			}
+			content = data
+			ctx.SetHeader("Content-Type", ctype, true)
+			ctx.SetHeader("Vary", "Accept", false)
+		}
+	}
+	if err := ctx.writeBody(content); err != nil {
+		ctx.Server.Logger.Println("Error during write: ", err)
+	}
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// checkHandler verifies that handler can be called by the router for a
+// route with numCaptures capture groups: it must be a function, optionally
+// taking a *Context first, followed by one parameter per capture group of a
+// type supported by convertArg, followed by services injected from s.Env,
+// which can't be of such a type.
+// It may return nothing, an error, or a value that can be written to the
+// response, optionally followed by an error. The services to inject are
+// returned.
+func (s *Server) checkHandler(handler reflect.Value, numCaptures int) ([]reflect.Value, error) {
+	if handler.Kind() != reflect.Func {
+		return nil, fmt.Errorf("expected a function or an http.Handler, got %v", handler.Type())
+	}
+	handlerType := handler.Type()
+
+	first := 0
+	if requiresContext(handlerType) {
+		first = 1
+	}
+	numParams := handlerType.NumIn() - first
+	if handlerType.IsVariadic() {
+		if numParams-1 > numCaptures {
+			return nil, fmt.Errorf("%v takes at least %d path parameters, but the route has %d capture groups", handlerType, numParams-1, numCaptures)
+		}
+	} else if numParams < numCaptures {
+		return nil, fmt.Errorf("%v takes %d parameters, but the route has %d capture groups", handlerType, numParams, numCaptures)
+	}
+
+	var services []reflect.Value
+	for i := first; i < handlerType.NumIn(); i++ {
+		t := argType(handlerType, i)
+		if handlerType.IsVariadic() || i < first+numCaptures {
+			if !convertible(t) {
+				return nil, fmt.Errorf("parameter %d of %v has unsupported type %v", i+1, handlerType, t)
+			}
+			continue
+		}
+		// a type that could be a path parameter is one that has no capture
+		// group, rather than a service
+		if convertible(t) {
+			return nil, fmt.Errorf("%v takes %d parameters, but the route has %d capture groups", handlerType, numParams, numCaptures)
+		}
+		service, err := s.service(t)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d of %v: %v", i+1, handlerType, err)
+		}
+		services = append(services, service)
+	}
+
+	switch handlerType.NumOut() {
+	case 0:
+	case 1:
+		if t := handlerType.Out(0); t != errorType && !writable(t) {
+			return nil, fmt.Errorf("unsupported return type %v", t)
+		}
+	case 2:
+		if t := handlerType.Out(0); !writable(t) {
+			return nil, fmt.Errorf("unsupported return type %v", t)
+		}
+		if t := handlerType.Out(1); t != errorType {
+			return nil, fmt.Errorf("the second return value must be an error, got %v", t)
+		}
+	default:
+		return nil, fmt.Errorf("%v returns too many values", handlerType)
+	}
+	return services, nil
+}
+
+// convertible reports whether convertArg supports values of type t.
+func convertible(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) || t.Kind() == reflect.Ptr && t.Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// writable reports whether handler results of type t can be written to the
+// response: strings, byte slices, values that are serialized, and
+// interfaces holding any of those.
+func writable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Interface:
+		return true
+	}
+	return serializable(t.Kind())
+}
+
+// takesPathArgs reports whether a handler takes path parameters as
+// arguments. Handlers that take a context and nothing that could be a path
+// parameter right after it don't; they read named parameters from
+// ctx.PathParams.
+func takesPathArgs(handlerType reflect.Type) bool {
+	if !requiresContext(handlerType) {
+		return true
+	}
+	return handlerType.NumIn() > 1 && convertible(argType(handlerType, 1))
+}
+
+// onlyNamedGroups reports whether cr has capture groups, all of them named.
+func onlyNamedGroups(cr *regexp.Regexp) bool {
+	names := cr.SubexpNames()
+	for _, name := range names[1:] {
+		if name == "" {
+			return false
+		}
+	}
+	return len(names) > 1
+}
+
+// argType returns the type of the i'th argument of a handler, taking
+// variadic handlers into account.
+func argType(handlerType reflect.Type, i int) reflect.Type {
+	if handlerType.IsVariadic() && i >= handlerType.NumIn()-1 {
+		return handlerType.In(handlerType.NumIn() - 1).Elem()
+	}
+	return handlerType.In(i)
+}
+
+// convertArg converts a value captured from the request path to the type
+// of the handler parameter it is passed as. Strings, booleans, numbers and
+// types implementing encoding.TextUnmarshaler are supported.
+func convertArg(s string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		v := reflect.New(t)
+		err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return v.Elem(), err
+	}
+	if t.Kind() == reflect.Ptr && t.Implements(textUnmarshalerType) {
+		v := reflect.New(t.Elem())
+		err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return v, err
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("Unsupported parameter type %v", t)
+	}
+	return v, nil
+}
+
+// argError is the error reported to the client when a path capture can't be
+// converted to the type of its handler parameter.
+func argError(value string, err error) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return &HTTPError{Code: 400, Message: fmt.Sprintf("Invalid path parameter %q: %v", value, err)}
+}
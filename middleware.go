@@ -0,0 +1,69 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Middleware wraps the handling of a request. It is given the request's
+// Context and a function that runs the rest of the chain; a middleware can
+// stop the request from reaching the handler by writing a response and not
+// calling next.
+type Middleware func(ctx *Context, next func())
+
+// Use adds middleware that runs around every route of server s, before the
+// middleware of groups. Each argument must be either a Middleware (or a
+// plain func(*Context, func())) or a standard net/http style middleware of
+// type func(http.Handler) http.Handler.
+func (s *Server) Use(middleware ...interface{}) {
+	for _, m := range middleware {
+		s.middleware = append(s.middleware, toMiddleware(m))
+	}
+}
+
+// toMiddleware converts the forms of middleware accepted by Use.
+func toMiddleware(m interface{}) Middleware {
+	switch m := m.(type) {
+	case Middleware:
+		return m
+	case func(*Context, func()):
+		return m
+	case func(http.Handler) http.Handler:
+		return wrapHTTPMiddleware(m)
+	}
+	panic(fmt.Sprintf("web: unsupported middleware type %T", m))
+}
+
+// wrapHTTPMiddleware adapts a net/http style middleware. If it replaces the
+// ResponseWriter or the Request before calling the next handler, the
+// replacements are used for the rest of the chain.
+func wrapHTTPMiddleware(m func(http.Handler) http.Handler) Middleware {
+	return func(ctx *Context, next func()) {
+		h := m(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx.ResponseWriter = w
+			ctx.Request = req
+			next()
+		}))
+		h.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+	}
+}
+
+// runMiddleware calls each middleware in turn, followed by handler.
+func runMiddleware(ctx *Context, middleware []Middleware, handler func()) {
+	if len(middleware) == 0 {
+		handler()
+		return
+	}
+	i := 0
+	var next func()
+	next = func() {
+		if i == len(middleware) {
+			handler()
+			return
+		}
+		m := middleware[i]
+		i++
+		m(ctx, next)
+	}
+	next()
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptRange is one entry of an Accept style header, such as "text/*;q=0.5".
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept splits an Accept, Accept-Language or Accept-Encoding header
+// into its ranges. Entries with an invalid quality are ignored.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if value == "" {
+			continue
+		}
+		q, valid := 1.0, true
+		for _, param := range fields[1:] {
+			name, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			valid = err == nil && q >= 0 && q <= 1
+		}
+		if valid {
+			ranges = append(ranges, acceptRange{value, q})
+		}
+	}
+	return ranges
+}
+
+// negotiate returns the offer with the highest quality in header, or "" if
+// none is acceptable. The quality of an offer is given by the most specific
+// range that matches it, as reported by match, or by def if none does. Ties
+// go to the offer named more specifically, then to the one listed first.
+func negotiate(header string, offers []string, match func(r string, offer string) (int, bool), def func(offer string) float64) string {
+	ranges := parseAccept(header)
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		q, specificity := def(strings.ToLower(offer)), -1
+		for _, r := range ranges {
+			if s, ok := match(r.value, strings.ToLower(offer)); ok && s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ || q == bestQ && q > 0 && specificity > bestSpecificity {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// matchMediaRange matches media types against ranges such as "text/html",
+// "text/*" and "*/*". Parameters of the offer are ignored.
+func matchMediaRange(r string, offer string) (int, bool) {
+	if i := strings.IndexByte(offer, ';'); i >= 0 {
+		offer = strings.TrimSpace(offer[:i])
+	}
+	switch {
+	case r == offer:
+		return 2, true
+	case r == "*/*" || r == "*":
+		return 0, true
+	case strings.HasSuffix(r, "/*") && strings.HasPrefix(offer, r[:len(r)-1]):
+		return 1, true
+	}
+	return 0, false
+}
+
+// matchLanguageRange matches language tags the way RFC 4647 basic filtering
+// does: "en" matches "en" and "en-GB", and "*" matches everything.
+func matchLanguageRange(r string, offer string) (int, bool) {
+	switch {
+	case r == "*":
+		return 0, true
+	case r == offer || strings.HasPrefix(offer, r+"-"):
+		return len(r), true
+	}
+	return 0, false
+}
+
+func matchEncodingRange(r string, offer string) (int, bool) {
+	switch r {
+	case offer:
+		return 1, true
+	case "*":
+		return 0, true
+	}
+	return 0, false
+}
+
+func noDefault(string) float64 { return 0 }
+
+// identityDefault makes the identity encoding acceptable when the
+// Accept-Encoding header doesn't mention it, though less than any other.
+func identityDefault(offer string) float64 {
+	if offer == "identity" {
+		return 0.001
+	}
+	return 0
+}
+
+// Negotiate picks the media type in offers that the client prefers according
+// to its Accept header, taking quality values and wildcards into account.
+// Among equally acceptable offers, one named in the header wins over one
+// matched by a wildcard, then the one given first. Without an Accept header
+// the first offer is returned. If nothing is acceptable it returns an error
+// with a status of 406.
+func (ctx *Context) Negotiate(offers ...string) (string, error) {
+	accept := ctx.Request.Header.Get("Accept")
+	if accept == "" && len(offers) > 0 {
+		return offers[0], nil
+	}
+	if best := negotiate(accept, offers, matchMediaRange, noDefault); best != "" {
+		return best, nil
+	}
+	return "", &HTTPError{Code: 406, Message: "Not acceptable, available types are " + strings.Join(offers, ", ")}
+}
+
+// Accepts returns the media type in offers that the client prefers, like
+// Negotiate, or "" if none is acceptable.
+func (ctx *Context) Accepts(offers ...string) string {
+	best, _ := ctx.Negotiate(offers...)
+	return best
+}
+
+// AcceptsLanguage returns the language tag in offers, such as "en-US", that
+// the client prefers according to its Accept-Language header, or "" if none
+// is acceptable. Without the header the first offer is returned.
+func (ctx *Context) AcceptsLanguage(offers ...string) string {
+	header := ctx.Request.Header.Get("Accept-Language")
+	if header == "" && len(offers) > 0 {
+		return offers[0]
+	}
+	return negotiate(header, offers, matchLanguageRange, noDefault)
+}
+
+// AcceptsEncoding returns the content coding in offers, such as "gzip", that
+// the client prefers according to its Accept-Encoding header, or "" if none
+// is acceptable. "identity" is acceptable unless the header rules it out.
+func (ctx *Context) AcceptsEncoding(offers ...string) string {
+	header := ctx.Request.Header.Get("Accept-Encoding")
+	if header == "" && len(offers) > 0 {
+		return offers[0]
+	}
+	return negotiate(header, offers, matchEncodingRange, identityDefault)
+}
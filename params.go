@@ -0,0 +1,217 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ParamError reports a request parameter that couldn't be parsed. Returned
+// from a handler, it produces a 400 response.
+type ParamError struct {
+	Name  string
+	Value string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("Invalid value %q for parameter %q: %v", e.Value, e.Name, e.Err)
+}
+
+// StatusCode returns 400.
+func (e *ParamError) StatusCode() int {
+	return 400
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// parseForm parses the query string and a url-encoded request body into
+// ctx.Params the first time it is called. Multipart bodies are left alone,
+// so that handlers can still read them with ctx.Request.MultipartReader. The
+// error is kept in ctx.formErr for ParseForm to return.
+func (ctx *Context) parseForm() error {
+	if ctx.formParsed {
+		return ctx.formErr
+	}
+	ctx.formParsed = true
+	if err := ctx.Request.ParseForm(); err != nil {
+		// ParseForm reports an error in the body before one in the query
+		if _, qerr := url.ParseQuery(ctx.Request.URL.RawQuery); qerr != nil && qerr.Error() == err.Error() {
+			ctx.formErr = &HTTPError{Code: 400, Message: "Malformed query string: " + err.Error()}
+		} else {
+			ctx.formErr = bodyError(err)
+		}
+	}
+	ctx.fillParams()
+	return ctx.formErr
+}
+
+// ParseForm parses the query string and the url-encoded or multipart request
+// body into ctx.Params, if that wasn't done already, and reports how it went.
+// A body over the size limit is reported with a status of 413, and other
+// malformed input with 400. The parameter accessors and Bind return the same
+// error, and handlers that only read ctx.Params can call it to check that
+// the parameters are complete.
+func (ctx *Context) ParseForm() error {
+	if ctx.parseForm() == nil && isMultipart(ctx.Request) {
+		if err := ctx.parseMultipart(); err != nil {
+			ctx.formErr = err
+		} else {
+			ctx.fillParams()
+		}
+	}
+	return ctx.formErr
+}
+
+// fillParams copies the first value of each parameter of the parsed form to
+// ctx.Params.
+func (ctx *Context) fillParams() {
+	for k, v := range ctx.Request.Form {
+		if ctx.Params == nil {
+			ctx.Params = map[string]string{}
+		}
+		ctx.Params[k] = v[0]
+	}
+}
+
+func isMultipart(req *http.Request) bool {
+	mediatype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return mediatype == "multipart/form-data"
+}
+
+// bodyError turns an error from reading the request body into an HTTPError.
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &HTTPError{Code: 413, Message: "Request body too large"}
+	}
+	return &HTTPError{Code: 400, Message: "Malformed request body: " + err.Error()}
+}
+
+// ParamValues returns all the values of a parameter, from both the query
+// string and the request body. Unlike ctx.Params, it keeps repeated keys.
+// Errors parsing the request are reported by ParseForm.
+func (ctx *Context) ParamValues(name string) []string {
+	ctx.ParseForm()
+	return ctx.Request.Form[name]
+}
+
+// QueryValues returns all the values of a parameter in the query string.
+func (ctx *Context) QueryValues(name string) []string {
+	if ctx.query == nil {
+		ctx.query = ctx.Request.URL.Query()
+	}
+	return ctx.query[name]
+}
+
+// BodyValues returns all the values of a parameter in a url-encoded or
+// multipart request body.
+func (ctx *Context) BodyValues(name string) []string {
+	ctx.ParseForm()
+	return ctx.Request.PostForm[name]
+}
+
+// param returns the first value of a parameter, with body parameters taking
+// precedence over the query string like in ctx.Params, along with the error
+// of ParseForm.
+func (ctx *Context) param(name string) (string, bool, error) {
+	err := ctx.ParseForm()
+	values := ctx.Request.Form[name]
+	if len(values) == 0 {
+		return "", false, err
+	}
+	return values[0], true, err
+}
+
+// ParamInt returns a parameter as an int. It returns def if the parameter is
+// missing, and def along with a *ParamError if it isn't a valid number.
+func (ctx *Context) ParamInt(name string, def int) (int, error) {
+	n, err := ctx.ParamInt64(name, int64(def))
+	if err != nil {
+		return def, err
+	}
+	if int64(int(n)) != n {
+		return def, ctx.paramError(name, strconv.ErrRange)
+	}
+	return int(n), nil
+}
+
+// ParamInt64 returns a parameter as an int64, see ParamInt.
+func (ctx *Context) ParamInt64(name string, def int64) (int64, error) {
+	v, ok, err := ctx.param(name)
+	if err != nil {
+		return def, err
+	}
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def, ctx.paramError(name, err)
+	}
+	return n, nil
+}
+
+// ParamFloat returns a parameter as a float64, see ParamInt.
+func (ctx *Context) ParamFloat(name string, def float64) (float64, error) {
+	v, ok, err := ctx.param(name)
+	if err != nil {
+		return def, err
+	}
+	if !ok {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def, ctx.paramError(name, err)
+	}
+	return f, nil
+}
+
+// ParamBool returns a parameter as a bool, accepting the values understood
+// by strconv.ParseBool. An empty value, as in "?debug", counts as true.
+func (ctx *Context) ParamBool(name string, def bool) (bool, error) {
+	v, ok, err := ctx.param(name)
+	if err != nil {
+		return def, err
+	}
+	if !ok {
+		return def, nil
+	}
+	if v == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, ctx.paramError(name, err)
+	}
+	return b, nil
+}
+
+// ParamTime parses a parameter with the given time layout. It returns the
+// zero time if the parameter is missing.
+func (ctx *Context) ParamTime(name string, layout string) (time.Time, error) {
+	v, ok, err := ctx.param(name)
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return time.Time{}, ctx.paramError(name, err)
+	}
+	return t, nil
+}
+
+func (ctx *Context) paramError(name string, err error) error {
+	value, _, _ := ctx.param(name)
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return &ParamError{Name: name, Value: value, Err: err}
+}
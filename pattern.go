@@ -0,0 +1,142 @@
+package web
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// defaultParamExpr is the expression used for named parameters that don't
+// specify their own, it matches a single path segment.
+const defaultParamExpr = `[^/]+`
+
+// expandRoute translates the named parameter syntax accepted by the router
+// into a regular expression that can be handed to regexp.Compile.
+//
+// Escaped characters are copied verbatim. Two forms are recognized:
+//
+//	/users/:id          a parameter spanning a whole path segment
+//	/users/{id:[0-9]+}  a parameter with its own expression
+//
+// `{id}` is shorthand for `{id:[^/]+}`. Parameters become named capture
+// groups, so they are still passed to handlers positionally. Everything else
+// in the route is left untouched, which means plain regex routes compile
+// exactly as before.
+func expandRoute(r string) string {
+	if !strings.ContainsAny(r, ":{") {
+		return r
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == '\\' && i+1 < len(r):
+			buf.WriteByte(c)
+			buf.WriteByte(r[i+1])
+			i++
+			continue
+		case c == ':' && i > 0 && r[i-1] == '/':
+			name := scanIdent(r[i+1:])
+			if name == "" {
+				break
+			}
+			writeParam(&buf, name, defaultParamExpr)
+			i += len(name)
+			continue
+		case c == '{':
+			name, expr, n := scanBraceParam(r[i:])
+			if n == 0 {
+				break
+			}
+			writeParam(&buf, name, expr)
+			i += n - 1
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+func writeParam(buf *bytes.Buffer, name string, expr string) {
+	buf.WriteString("(?P<")
+	buf.WriteString(name)
+	buf.WriteString(">")
+	buf.WriteString(expr)
+	buf.WriteString(")")
+}
+
+// scanBraceParam parses a `{name}` or `{name:expr}` parameter at the start of
+// s. It returns the length of the parameter including both braces, or 0 if s
+// doesn't start with one (for instance a regex repetition like `{2,3}`).
+func scanBraceParam(s string) (name string, expr string, n int) {
+	name = scanIdent(s[1:])
+	if name == "" {
+		return "", "", 0
+	}
+	rest := s[1+len(name):]
+	if strings.HasPrefix(rest, "}") {
+		return name, defaultParamExpr, len(name) + 2
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return "", "", 0
+	}
+	// the expression may itself contain braces, e.g. {id:[0-9]{4}}
+	depth := 1
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				expr = rest[1:i]
+				if expr == "" {
+					expr = defaultParamExpr
+				}
+				return name, expr, len(name) + 2 + i
+			}
+		}
+	}
+	return "", "", 0
+}
+
+// paramSegment reports whether the path segment seg consists of a single
+// named parameter, and returns the expression it matches.
+func paramSegment(seg string) (string, bool) {
+	switch {
+	case strings.HasPrefix(seg, ":"):
+		name := scanIdent(seg[1:])
+		return defaultParamExpr, name != "" && len(name) == len(seg)-1
+	case strings.HasPrefix(seg, "{"):
+		_, expr, n := scanBraceParam(seg)
+		return expr, n == len(seg)
+	}
+	return "", false
+}
+
+// scanIdent returns the identifier at the start of s, if any.
+func scanIdent(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// quotePrefix escapes the regex metacharacters in a literal route prefix so
+// it can be prepended to a route. Segments that are named parameters, such as
+// `:version` in "/api/:version", are left as they are.
+func quotePrefix(prefix string) string {
+	parts := strings.Split(strings.TrimSuffix(prefix, "/"), "/")
+	for i, part := range parts {
+		if _, ok := paramSegment(part); !ok {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
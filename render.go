@@ -0,0 +1,116 @@
+package web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// JSON writes v encoded as JSON with the given status. Nothing is written if
+// v can't be encoded, and the error is returned.
+func (ctx *Context) JSON(status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ctx.Blob(status, "application/json; charset=utf-8", data)
+}
+
+// XML writes v encoded as XML with the given status. Nothing is written if v
+// can't be encoded, and the error is returned.
+func (ctx *Context) XML(status int, v interface{}) error {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ctx.Blob(status, "application/xml; charset=utf-8", data)
+}
+
+// Text writes s as plain text with the given status.
+func (ctx *Context) Text(status int, s string) error {
+	return ctx.Blob(status, "text/plain; charset=utf-8", []byte(s))
+}
+
+// Blob writes data with the given status and content type. The body is left
+// out of responses to HEAD requests, but Content-Length still gives its size.
+func (ctx *Context) Blob(status int, ctype string, data []byte) error {
+	ctx.SetHeader("Content-Type", ctype, true)
+	ctx.SetHeader("Content-Length", strconv.Itoa(len(data)), true)
+	ctx.ResponseWriter.WriteHeader(status)
+	if ctx.Request.Method == "HEAD" {
+		return nil
+	}
+	_, err := ctx.ResponseWriter.Write(data)
+	return err
+}
+
+// Stream copies r to the response with the given status and content type.
+// Content-Length is only set if the length of r is known, as for a
+// *bytes.Reader or a *strings.Reader. r isn't read for HEAD requests.
+func (ctx *Context) Stream(status int, ctype string, r io.Reader) error {
+	ctx.SetHeader("Content-Type", ctype, true)
+	if lr, ok := r.(interface{ Len() int }); ok {
+		ctx.SetHeader("Content-Length", strconv.Itoa(lr.Len()), true)
+	}
+	ctx.ResponseWriter.WriteHeader(status)
+	if ctx.Request.Method == "HEAD" {
+		return nil
+	}
+	_, err := io.Copy(ctx.ResponseWriter, r)
+	return err
+}
+
+// writeBody writes the content returned by a handler, with the status set
+// by the handler or 200. The body is left out of responses to HEAD requests.
+func (ctx *Context) writeBody(content []byte) error {
+	ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
+	if ctx.Request.Method == "HEAD" {
+		// still send the headers, if the handler hasn't yet
+		ctx.ResponseWriter.Write(nil)
+		return nil
+	}
+	_, err := ctx.ResponseWriter.Write(content)
+	return err
+}
+
+// serializable reports whether handler results of kind k are encoded as
+// JSON or XML rather than written as they are.
+func serializable(k reflect.Kind) bool {
+	switch k {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr:
+		return true
+	}
+	return false
+}
+
+// serialize encodes v as JSON, or as XML if the request prefers it, and
+// returns the encoded data along with its content type. XML is only offered
+// for structs, as maps and slices have no XML encoding, and not to browsers:
+// they list application/xml in their Accept header, above */*, but are
+// better served JSON. A struct that can't be encoded as XML is an error
+// rather than a reason to switch to JSON. JSON is used when neither is
+// acceptable.
+func (ctx *Context) serialize(v interface{}) ([]byte, string, error) {
+	if reflect.Indirect(reflect.ValueOf(v)).Kind() == reflect.Struct && !ctx.fromBrowser() {
+		switch ctx.Accepts("application/json", "application/xml", "text/xml") {
+		case "application/xml", "text/xml":
+			data, err := xml.Marshal(v)
+			return data, "application/xml; charset=utf-8", err
+		}
+	}
+	data, err := json.Marshal(v)
+	return data, "application/json; charset=utf-8", err
+}
+
+// fromBrowser reports whether the Accept header of the request names HTML,
+// as those of browsers do.
+func (ctx *Context) fromBrowser() bool {
+	for _, r := range parseAccept(ctx.Request.Header.Get("Accept")) {
+		if r.value == "text/html" && r.q > 0 {
+			return true
+		}
+	}
+	return false
+}
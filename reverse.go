@@ -0,0 +1,103 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// URLFor builds the path of the route registered under `name`, substituting
+// args for its capture groups in order. Each argument is formatted with
+// fmt.Sprint, checked against the expression of its group and escaped.
+// An error is returned if there is no such route, the arguments don't fit,
+// or the route contains expressions outside of capture groups that can't be
+// turned back into a path, such as alternations or repetitions.
+func (s *Server) URLFor(name string, args ...interface{}) (string, error) {
+	rt, ok := s.named[name]
+	if !ok {
+		return "", fmt.Errorf("No route named %q", name)
+	}
+	return rt.reverse(args)
+}
+
+// URLFor builds the path of a named route of the server handling the request.
+func (ctx *Context) URLFor(name string, args ...interface{}) (string, error) {
+	return ctx.Server.URLFor(name, args...)
+}
+
+// reverse substitutes args into the route's expression.
+func (rt *Route) reverse(args []interface{}) (string, error) {
+	re, err := syntax.Parse(rt.cr.String(), syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	rev := reverser{route: rt.r, args: args}
+	if err := rev.write(re); err != nil {
+		return "", err
+	}
+	if rev.n != len(args) {
+		return "", fmt.Errorf("Route %q takes %d arguments, got %d", rt.r, rev.n, len(args))
+	}
+	return rev.buf.String(), nil
+}
+
+type reverser struct {
+	route string
+	args  []interface{}
+	// number of arguments consumed so far
+	n   int
+	buf bytes.Buffer
+}
+
+func (rev *reverser) write(re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		rev.buf.WriteString(string(re.Rune))
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := rev.write(sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpCapture:
+		return rev.writeArg(re.Sub[0])
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+	default:
+		return fmt.Errorf("Route %q cannot be reversed: unsupported expression %q", rev.route, re.String())
+	}
+	return nil
+}
+
+// writeArg validates the next argument against the expression of a capture
+// group and writes it escaped.
+func (rev *reverser) writeArg(re *syntax.Regexp) error {
+	if re.MaxCap() > 0 {
+		return fmt.Errorf("Route %q cannot be reversed: nested capture groups", rev.route)
+	}
+	if rev.n >= len(rev.args) {
+		rev.n++
+		return nil
+	}
+	arg := fmt.Sprint(rev.args[rev.n])
+	rev.n++
+
+	cr, err := regexp.Compile(`^(?:` + re.String() + `)$`)
+	if err != nil {
+		return err
+	}
+	if !cr.MatchString(arg) {
+		return fmt.Errorf("Argument %d of route %q doesn't match %q: %q", rev.n, rev.route, re.String(), arg)
+	}
+
+	// escape each segment, so arguments spanning several segments keep their
+	// slashes
+	segs := strings.Split(arg, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	rev.buf.WriteString(strings.Join(segs, "/"))
+	return nil
+}
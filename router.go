@@ -0,0 +1,334 @@
+package web
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// node is a node of the route tree. Each edge consumes one path segment,
+// either a literal one through `static` or any non-empty one through `param`.
+// Routes that can be expressed this way are dispatched without running a
+// regular expression; everything else falls back to the regex engine, see
+// regexNode.
+type node struct {
+	static map[string]*node
+	param  *node
+	// routes ending at this node, in registration order
+	routes []*Route
+}
+
+// treeSegments splits route r into the segments used by the route tree,
+// along with which of them are whole-segment parameters such as `:id`,
+// `{id}` or `([^/]+)`. It returns false if r needs the regex engine.
+func treeSegments(r string) ([]string, []bool, bool) {
+	if !strings.HasPrefix(r, "/") {
+		return nil, nil, false
+	}
+	parts := splitSegments(r[1:])
+	segs := make([]string, len(parts))
+	params := make([]bool, len(parts))
+	for i, part := range parts {
+		if isParamSegment(part) {
+			params[i] = true
+			continue
+		}
+		// parameters mixed with other text, such as `:id-x`, or with their
+		// own expression need the regex engine
+		if expandRoute("/"+part) != "/"+part {
+			return nil, nil, false
+		}
+		lit, ok := literalSegment(part)
+		if !ok {
+			return nil, nil, false
+		}
+		segs[i] = lit
+	}
+	return segs, params, true
+}
+
+// splitSegments splits r at the slashes that aren't escaped or within a
+// character class, such as the one in `([^/]+)`.
+func splitSegments(r string) []string {
+	var parts []string
+	start, inClass := 0, false
+	for i := 0; i < len(r); i++ {
+		switch r[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				parts = append(parts, r[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, r[start:])
+}
+
+// isParamSegment reports whether seg is a parameter that matches a whole
+// path segment, either named or an unnamed `([^/]+)` group.
+func isParamSegment(seg string) bool {
+	if seg == "("+defaultParamExpr+")" {
+		return true
+	}
+	expr, ok := paramSegment(seg)
+	return ok && expr == defaultParamExpr
+}
+
+// countTrue returns the number of true values in bs.
+func countTrue(bs []bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// literalSegment returns the string matched by seg if seg is a regular
+// expression that only matches a literal, such as `robots\.txt`.
+func literalSegment(seg string) (string, bool) {
+	if regexp.QuoteMeta(seg) == seg {
+		return seg, true
+	}
+	cr, err := regexp.Compile(seg)
+	if err != nil || cr.NumSubexp() > 0 {
+		return "", false
+	}
+	lit, complete := cr.LiteralPrefix()
+	if !complete || strings.Contains(lit, "/") {
+		return "", false
+	}
+	return lit, true
+}
+
+// insert adds rt to the tree under the given segments.
+func (n *node) insert(segs []string, params []bool, rt *Route) {
+	for i, seg := range segs {
+		var child *node
+		if params[i] {
+			if n.param == nil {
+				n.param = &node{}
+			}
+			child = n.param
+		} else {
+			if n.static == nil {
+				n.static = map[string]*node{}
+			}
+			child = n.static[seg]
+			if child == nil {
+				child = &node{}
+				n.static[seg] = child
+			}
+		}
+		n = child
+	}
+	n.routes = append(n.routes, rt)
+}
+
+// treeMatch holds the best route found so far while searching the tree.
+// If method is empty, every route matching the path is collected in `all`
+// instead.
+type treeMatch struct {
+	method string
+	route  *Route
+	params []string
+	all    []*Route
+}
+
+// find searches the subtree for routes matching path, which is the remainder
+// of the request path after the segments consumed so far. Because several
+// branches can match, the whole tree is searched and the route registered
+// first wins, exactly as if every route were tried in order.
+func (n *node) find(path string, params []string, m *treeMatch) {
+	seg, rest, last := path, "", true
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		seg, rest, last = path[:i], path[i+1:], false
+	}
+	if child := n.static[seg]; child != nil {
+		child.descend(rest, last, params, m)
+	}
+	if n.param != nil && seg != "" {
+		n.param.descend(rest, last, append(params, seg), m)
+	}
+}
+
+func (n *node) descend(rest string, last bool, params []string, m *treeMatch) {
+	if !last {
+		n.find(rest, params, m)
+		return
+	}
+	if m.method == "" {
+		m.all = append(m.all, n.routes...)
+		return
+	}
+	for _, rt := range n.routes {
+		if m.route != nil && m.route.index < rt.index {
+			break
+		}
+		if rt.matchesMethod(m.method) {
+			m.route = rt
+			m.params = append(m.params[:0], params...)
+			break
+		}
+	}
+}
+
+// regexNode indexes the routes that need the regex engine by the literal
+// prefix of their expression, split into path segments: the route
+// "/users/(\d+)" is kept under "users", and only tried for paths in
+// /users/. Routes without such a prefix are kept at the root.
+type regexNode struct {
+	children map[string]*regexNode
+	// routes whose prefix ends at this node, in registration order
+	routes []*Route
+}
+
+// insert adds rt under the complete path segments of its literal prefix.
+func (n *regexNode) insert(rt *Route) {
+	if prefix, _ := rt.cr.LiteralPrefix(); strings.HasPrefix(prefix, "/") {
+		segs := strings.Split(prefix[1:], "/")
+		// the last segment is incomplete, or empty if prefix ends in '/'
+		for _, seg := range segs[:len(segs)-1] {
+			if n.children == nil {
+				n.children = map[string]*regexNode{}
+			}
+			child := n.children[seg]
+			if child == nil {
+				child = &regexNode{}
+				n.children[seg] = child
+			}
+			n = child
+		}
+	}
+	n.routes = append(n.routes, rt)
+}
+
+// candidates returns the routes whose literal prefix may match path, in
+// registration order.
+func (n *regexNode) candidates(path string) []*Route {
+	if n == nil {
+		return nil
+	}
+	routes := n.routes
+	if !strings.HasPrefix(path, "/") {
+		return routes
+	}
+	rest := path[1:]
+	for {
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			return routes
+		}
+		if n = n.children[rest[:i]]; n == nil {
+			return routes
+		}
+		routes = mergeRoutes(routes, n.routes)
+		rest = rest[i+1:]
+	}
+}
+
+// mergeRoutes merges two lists of routes sorted by registration order.
+func mergeRoutes(a []*Route, b []*Route) []*Route {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	merged := make([]*Route, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].index < b[0].index {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// matchesMethod reports whether the route handles the given request method.
+// HEAD can be used in place of GET.
+func (rt *Route) matchesMethod(method string) bool {
+	return method == rt.method || (method == "HEAD" && rt.method == "GET")
+}
+
+// match returns the submatches of path if the route's regex matches it in
+// its entirety, or nil.
+func (rt *Route) match(path string) []string {
+	if prefix, _ := rt.cr.LiteralPrefix(); !strings.HasPrefix(path, prefix) {
+		return nil
+	}
+	match := rt.cr.FindStringSubmatch(path)
+	if match == nil || len(match[0]) != len(path) {
+		return nil
+	}
+	return match
+}
+
+// findRoute returns the first registered route matching the method and path,
+// along with the submatches to pass to its handler.
+func (s *Server) findRoute(method string, path string) (*Route, []string) {
+	var m treeMatch
+	if s.tree != nil && strings.HasPrefix(path, "/") {
+		m.method = method
+		s.tree.find(path[1:], nil, &m)
+	}
+	for _, rt := range s.regexTree.candidates(path) {
+		if m.route != nil && rt.index > m.route.index {
+			break
+		}
+		if !rt.matchesMethod(method) {
+			continue
+		}
+		if match := rt.match(path); match != nil {
+			return rt, match
+		}
+	}
+	if m.route == nil {
+		return nil, nil
+	}
+	return m.route, append([]string{path}, m.params...)
+}
+
+// allowedMethods returns the methods of every route matching path, regardless
+// of the request method. HEAD is implied by GET, and OPTIONS is included when
+// it is answered automatically.
+func (s *Server) allowedMethods(path string) []string {
+	var m treeMatch
+	if s.tree != nil && strings.HasPrefix(path, "/") {
+		s.tree.find(path[1:], nil, &m)
+	}
+	for _, rt := range s.regexTree.candidates(path) {
+		if rt.match(path) != nil {
+			m.all = append(m.all, rt)
+		}
+	}
+	if len(m.all) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	if s.Config.AutoOptions {
+		seen["OPTIONS"] = true
+	}
+	for _, rt := range m.all {
+		seen[rt.method] = true
+		if rt.method == "GET" {
+			seen["HEAD"] = true
+		}
+	}
+	methods := make([]string, 0, len(seen))
+	for method := range seen {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
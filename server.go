@@ -2,9 +2,11 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"golang.org/x/net/websocket"
+	"io/fs"
 	"log"
 	"net"
 	"net/http"
@@ -14,28 +16,97 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // ServerConfig is configuration for server objects.
 type ServerConfig struct {
-	StaticDir    string
+	// StaticDir is the directory static files are served from. It defaults
+	// to the "static" directory in the working directory.
+	StaticDir string
+	// StaticFS, if set, is served in place of StaticDir, for instance an
+	// embed.FS compiled into the program.
+	StaticFS fs.FS
+	// StaticCache sets the Cache-Control header of static files. The first
+	// policy that applies to a file is used.
+	StaticCache  []CachePolicy
 	Addr         string
 	Port         int
 	CookieSecret string
 	RecoverPanic bool
 	Profiler     bool
 	ColorOutput  bool
+	// StrictRoutes makes adding an invalid route panic instead of logging
+	// the error, so mistakes are caught at startup.
+	StrictRoutes bool
+	// AutoOptions answers OPTIONS requests for paths that have routes but no
+	// OPTIONS handler, with an Allow header listing the registered methods.
+	AutoOptions bool
+	// MaxBodyBytes limits the size of request bodies, zero means no limit.
+	// Reading past the limit fails. Routes can override it with
+	// Route.MaxBodyBytes.
+	//
+	// As ctx.Params is filled before the handler runs, a url-encoded form is
+	// parsed as soon as a route matches if its handler takes a *Context or
+	// it has middleware, whether or not they use the parameters. The handler
+	// is called even if the body is over the limit or malformed: ctx.Params
+	// is then incomplete, and ctx.ParseForm, the parameter accessors and
+	// ctx.Bind return an error with a status of 413 or 400. Multipart bodies
+	// are only parsed when ctx.ParseForm, ctx.ParamValues, ctx.BodyValues,
+	// ctx.FormFile, ctx.FormFiles or ctx.Bind need them. Other handlers only
+	// read the body themselves.
+	MaxBodyBytes int64
+	// MultipartMemory is how much of a multipart request body is kept in
+	// memory, the rest is stored in temporary files. It defaults to 32 MB.
+	MultipartMemory int64
+	// MaxFileBytes limits the size of each file read with ctx.FormFile and
+	// ctx.FormFiles, zero means no limit. Files are checked after the whole
+	// multipart body has been parsed, so it doesn't limit how much of the
+	// request is read or stored in temporary files: set MaxBodyBytes as well
+	// to bound uploads.
+	MaxFileBytes int64
+	// AllowedFileTypes lists the media types accepted by ctx.FormFile and
+	// ctx.FormFiles, such as "application/pdf" or "image/*". The type is
+	// detected from the content of the file, once the body has been parsed
+	// like for MaxFileBytes. All types are accepted if it is empty.
+	AllowedFileTypes []string
 }
 
 // Server represents a web.go server.
 type Server struct {
 	Config *ServerConfig
-	routes []route
-	Logger *log.Logger
-	Env    map[string]interface{}
+	routes []*Route
+	// routes that can't be dispatched through the tree
+	regexTree  *regexNode
+	tree       *node
+	named      map[string]*Route
+	middleware []Middleware
+	Logger     *log.Logger
+	Env        map[string]interface{}
+	// NotFoundHandler, if set, responds to requests that don't match any
+	// route or static file, instead of the default 404 page.
+	NotFoundHandler func(ctx *Context)
+	// MethodNotAllowedHandler, if set, responds to requests whose path
+	// matches a route but not its method, instead of the default 405 page.
+	// The Allow header is already set when it is called.
+	MethodNotAllowedHandler func(ctx *Context)
+	// ErrorHandler, if set, responds to requests whose handler failed,
+	// instead of the default 500 page. If the handler panicked, err is a
+	// *PanicError holding the recovered value.
+	ErrorHandler func(ctx *Context, err error)
+	// Templates configures the templates rendered with ctx.Render.
+	Templates *TemplateConfig
+	// static files mounted with Static
+	static []staticMount
+	// the parsed templates
+	templates   *templateSet
+	templatesMu sync.Mutex
+	// the context requests are derived from, canceled by Close
+	ctx     context.Context
+	cancel  context.CancelFunc
+	ctxOnce sync.Once
 	//save the listener so it can be closed
 	l       net.Listener
 	encKey  []byte
@@ -66,30 +137,207 @@ func (s *Server) initServer() {
 	}
 }
 
-type route struct {
+// Route is a handle to a route registered on a server, returned by Get, Post
+// and the other registration methods.
+type Route struct {
+	server      *Server
 	r           string
 	cr          *regexp.Regexp
 	method      string
 	handler     reflect.Value
 	httpHandler http.Handler
+	// calls handler, see compileInvoker
+	invoke invoker
+	// position in registration order, earlier routes take precedence
+	index int
+	// the group the route was added to, if any
+	group *RouteGroup
+	name  string
+	// middleware added to this route only
+	own  []Middleware
+	meta map[string]interface{}
+	// why the route couldn't be added
+	err error
+	// when the request context is canceled, zero for no limit
+	timeout time.Duration
+	// overrides Config.MaxBodyBytes if not zero, negative for no limit
+	maxBodyBytes int64
+	// whether the handler takes a *Context
+	withContext bool
 }
 
-func (s *Server) addRoute(r string, method string, handler interface{}) {
-	cr, err := regexp.Compile(r)
+// Err returns the reason the route couldn't be added, such as an invalid
+// regular expression or a handler that doesn't fit the route. Routes with an
+// error are not served.
+func (rt *Route) Err() error {
+	return rt.err
+}
+
+// Pattern returns the route expression as it was registered.
+func (rt *Route) Pattern() string {
+	return rt.r
+}
+
+// Method returns the http method handled by the route.
+func (rt *Route) Method() string {
+	return rt.method
+}
+
+// Use adds middleware that only runs for this route, after the middleware of
+// the server and of its groups. It accepts the same forms of middleware as
+// Server.Use.
+func (rt *Route) Use(middleware ...interface{}) *Route {
+	for _, m := range middleware {
+		rt.own = append(rt.own, toMiddleware(m))
+	}
+	return rt
+}
+
+// SetMeta attaches an arbitrary value to the route. Middleware can read it
+// through ctx.Route, e.g. to find out which permissions a route requires.
+func (rt *Route) SetMeta(key string, value interface{}) *Route {
+	if rt.meta == nil {
+		rt.meta = map[string]interface{}{}
+	}
+	rt.meta[key] = value
+	return rt
+}
+
+// Timeout sets how long requests to the route may take. When it expires the
+// request's context, and so the Context of the handler, is canceled. The
+// handler isn't interrupted; it is expected to watch ctx.Done().
+func (rt *Route) Timeout(d time.Duration) *Route {
+	rt.timeout = d
+	return rt
+}
+
+// MaxBodyBytes sets the largest request body the route accepts, in place of
+// the server's Config.MaxBodyBytes. A negative n removes the limit.
+func (rt *Route) MaxBodyBytes(n int64) *Route {
+	rt.maxBodyBytes = n
+	return rt
+}
+
+// bodyLimit returns the limit on request bodies for the route, or 0.
+func (rt *Route) bodyLimit() int64 {
+	if rt.maxBodyBytes != 0 {
+		if rt.maxBodyBytes < 0 {
+			return 0
+		}
+		return rt.maxBodyBytes
+	}
+	if rt.server.Config != nil {
+		return rt.server.Config.MaxBodyBytes
+	}
+	return 0
+}
+
+// Meta returns the value attached to the route under key, or nil.
+func (rt *Route) Meta(key string) interface{} {
+	return rt.meta[key]
+}
+
+// Name gives the route a name, so that URLs for it can be built with URLFor.
+func (rt *Route) Name(name string) *Route {
+	rt.name = name
+	if rt.server != nil {
+		if rt.server.named == nil {
+			rt.server.named = map[string]*Route{}
+		}
+		rt.server.named[name] = rt
+	}
+	return rt
+}
+
+// middleware returns the middleware to run around the route's handler,
+// outermost first.
+func (rt *Route) middleware() []Middleware {
+	var group []Middleware
+	if rt.group != nil {
+		group = rt.group.chain()
+	}
+	switch {
+	case len(group) == 0 && len(rt.own) == 0:
+		return rt.server.middleware
+	case len(rt.server.middleware) == 0 && len(rt.own) == 0:
+		return group
+	}
+	chain := make([]Middleware, 0, len(rt.server.middleware)+len(group)+len(rt.own))
+	chain = append(chain, rt.server.middleware...)
+	chain = append(chain, group...)
+	return append(chain, rt.own...)
+}
+
+func (s *Server) addRoute(r string, method string, handler interface{}) *Route {
+	rt := &Route{server: s, r: r, method: method, index: len(s.routes)}
+	if err := rt.init(handler); err != nil {
+		s.routeError(err)
+		// hand back a route that isn't registered, so calls on it are harmless
+		return &Route{r: r, method: method, err: err}
+	}
+	s.routes = append(s.routes, rt)
+
+	if segs, params, ok := treeSegments(r); ok && countTrue(params) == rt.cr.NumSubexp() {
+		if s.tree == nil {
+			s.tree = &node{}
+		}
+		s.tree.insert(segs, params, rt)
+	} else {
+		if s.regexTree == nil {
+			s.regexTree = &regexNode{}
+		}
+		s.regexTree.insert(rt)
+	}
+	return rt
+}
+
+// init compiles the route and checks that its handler can be called with
+// the groups it captures.
+func (rt *Route) init(handler interface{}) error {
+	cr, err := regexp.Compile(expandRoute(rt.r))
 	if err != nil {
-		s.Logger.Printf("Error in route regex %q\n", r)
-		return
+		return fmt.Errorf("Error in route regex %q: %v", rt.r, err)
 	}
+	rt.cr = cr
 
 	switch handler.(type) {
 	case http.Handler:
-		s.routes = append(s.routes, route{r: r, cr: cr, method: method, httpHandler: handler.(http.Handler)})
+		rt.httpHandler = handler.(http.Handler)
+		return nil
 	case reflect.Value:
-		fv := handler.(reflect.Value)
-		s.routes = append(s.routes, route{r: r, cr: cr, method: method, handler: fv})
+		rt.handler = handler.(reflect.Value)
 	default:
-		fv := reflect.ValueOf(handler)
-		s.routes = append(s.routes, route{r: r, cr: cr, method: method, handler: fv})
+		rt.handler = reflect.ValueOf(handler)
+	}
+	numCaptures := cr.NumSubexp()
+	byName := onlyNamedGroups(cr) && !takesPathArgs(rt.handler.Type())
+	if byName {
+		numCaptures = 0
+	}
+	services, err := rt.server.checkHandler(rt.handler, numCaptures)
+	if err != nil {
+		return fmt.Errorf("Invalid handler for route %q: %v", rt.r, err)
+	}
+	rt.invoke = compileInvoker(rt.handler, services)
+	if byName {
+		// the parameters are read from ctx.PathParams instead
+		invoke := rt.invoke
+		rt.invoke = func(ctx *Context, captures []string) (interface{}, error) {
+			return invoke(ctx, nil)
+		}
+	}
+	rt.withContext = requiresContext(rt.handler.Type())
+	return nil
+}
+
+// routeError reports a route that couldn't be added, and panics in strict
+// mode.
+func (s *Server) routeError(err error) {
+	if s.Config != nil && s.Config.StrictRoutes {
+		panic(err)
+	}
+	if s.Logger != nil {
+		s.Logger.Println(err)
 	}
 }
 
@@ -100,45 +348,42 @@ func (s *Server) ServeHTTP(c http.ResponseWriter, req *http.Request) {
 
 // Process invokes the routing system for server s
 func (s *Server) Process(c http.ResponseWriter, req *http.Request) {
-	route := s.routeHandler(req, c)
-	if route != nil {
-		route.httpHandler.ServeHTTP(c, req)
-	}
+	s.routeHandler(req, c)
 }
 
 // Get adds a handler for the 'GET' http method for server s.
-func (s *Server) Get(route string, handler interface{}) {
-	s.addRoute(route, "GET", handler)
+func (s *Server) Get(route string, handler interface{}) *Route {
+	return s.addRoute(route, "GET", handler)
 }
 
 // Post adds a handler for the 'POST' http method for server s.
-func (s *Server) Post(route string, handler interface{}) {
-	s.addRoute(route, "POST", handler)
+func (s *Server) Post(route string, handler interface{}) *Route {
+	return s.addRoute(route, "POST", handler)
 }
 
 // Put adds a handler for the 'PUT' http method for server s.
-func (s *Server) Put(route string, handler interface{}) {
-	s.addRoute(route, "PUT", handler)
+func (s *Server) Put(route string, handler interface{}) *Route {
+	return s.addRoute(route, "PUT", handler)
 }
 
 // Delete adds a handler for the 'DELETE' http method for server s.
-func (s *Server) Delete(route string, handler interface{}) {
-	s.addRoute(route, "DELETE", handler)
+func (s *Server) Delete(route string, handler interface{}) *Route {
+	return s.addRoute(route, "DELETE", handler)
 }
 
 // Match adds a handler for an arbitrary http method for server s.
-func (s *Server) Match(method string, route string, handler interface{}) {
-	s.addRoute(route, method, handler)
+func (s *Server) Match(method string, route string, handler interface{}) *Route {
+	return s.addRoute(route, method, handler)
 }
 
 // Add a custom http.Handler. Will have no effect when running as FCGI or SCGI.
-func (s *Server) Handle(route string, method string, httpHandler http.Handler) {
-	s.addRoute(route, method, httpHandler)
+func (s *Server) Handle(route string, method string, httpHandler http.Handler) *Route {
+	return s.addRoute(route, method, httpHandler)
 }
 
 //Adds a handler for websockets. Only for webserver mode. Will have no effect when running as FCGI or SCGI.
-func (s *Server) Websocket(route string, httpHandler websocket.Handler) {
-	s.addRoute(route, "GET", httpHandler)
+func (s *Server) Websocket(route string, httpHandler websocket.Handler) *Route {
+	return s.addRoute(route, "GET", httpHandler)
 }
 
 // Run starts the web application and serves HTTP requests for s
@@ -197,23 +442,32 @@ func (s *Server) RunTLS(addr string, config *tls.Config) error {
 	return http.Serve(s.l, mux)
 }
 
-// Close stops server s.
+// Close stops server s, and cancels the context of the requests in flight.
 func (s *Server) Close() {
 	if s.l != nil {
 		s.l.Close()
 	}
+	s.context()
+	s.cancel()
+}
+
+// context returns the context of the server, which is canceled by Close.
+func (s *Server) context() context.Context {
+	s.ctxOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+	return s.ctx
 }
 
-// safelyCall invokes `function` in recover block
-func (s *Server) safelyCall(function reflect.Value, args []reflect.Value) (resp []reflect.Value, e interface{}) {
+// safelyCall invokes `function` in recover block, and hands the recovered
+// value to the error handler
+func (s *Server) safelyCall(ctx *Context, function func()) {
 	defer func() {
 		if err := recover(); err != nil {
 			if !s.Config.RecoverPanic {
 				// go back to panic
 				panic(err)
 			} else {
-				e = err
-				resp = nil
 				s.Logger.Println("Handler crashed with error", err)
 				for i := 1; ; i += 1 {
 					_, file, line, ok := runtime.Caller(i)
@@ -222,22 +476,28 @@ func (s *Server) safelyCall(function reflect.Value, args []reflect.Value) (resp
 					}
 					s.Logger.Println(file, line)
 				}
+				s.handleError(ctx, &PanicError{Value: err})
 			}
 		}
 	}()
-	return function.Call(args), nil
+	function()
 }
 
 // requiresContext determines whether 'handlerType' contains
-// an argument to 'web.Ctx' as its first argument
+// an argument to 'web.Ctx' or 'context.Context' as its first argument
 func requiresContext(handlerType reflect.Type) bool {
 	//if the method doesn't take arguments, no
 	if handlerType.NumIn() == 0 {
 		return false
 	}
 
-	//if the first argument is not a pointer, no
+	//a context.Context is given the web.Context, which implements it
 	a0 := handlerType.In(0)
+	if a0 == stdContextType {
+		return true
+	}
+
+	//if the first argument is not a pointer, no
 	if a0.Kind() != reflect.Ptr {
 		return false
 	}
@@ -249,32 +509,6 @@ func requiresContext(handlerType reflect.Type) bool {
 	return false
 }
 
-// tryServingFile attempts to serve a static file, and returns
-// whether or not the operation is successful.
-// It checks the following directories for the file, in order:
-// 1) Config.StaticDir
-// 2) The 'static' directory in the parent directory of the executable.
-// 3) The 'static' directory in the current working directory
-func (s *Server) tryServingFile(name string, req *http.Request, w http.ResponseWriter) bool {
-	//try to serve a static file
-	if s.Config.StaticDir != "" {
-		staticFile := path.Join(s.Config.StaticDir, name)
-		if fileExists(staticFile) {
-			http.ServeFile(w, req, staticFile)
-			return true
-		}
-	} else {
-		for _, staticDir := range defaultStaticDirs {
-			staticFile := path.Join(staticDir, name)
-			if fileExists(staticFile) {
-				http.ServeFile(w, req, staticFile)
-				return true
-			}
-		}
-	}
-	return false
-}
-
 func (s *Server) logRequest(ctx Context, sTime time.Time) {
 	//log the request
 	req := ctx.Request
@@ -321,25 +555,15 @@ func (s *Server) ttyColor(msg string, colorCode string) string {
 // the main route handler in web.go
 // Tries to handle the given request.
 // Finds the route matching the request, and execute the callback associated
-// with it.  In case of custom http handlers, this function returns an "unused"
-// route. The caller is then responsible for calling the httpHandler associated
-// with the returned route.
-func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused *route) {
+// with it, wrapped in the middleware of the route.
+func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 	requestPath := req.URL.Path
-	ctx := Context{req, map[string]string{}, s, w}
+	ctx := Context{Request: req, Params: map[string]string{}, Server: s, ResponseWriter: w}
 
 	//set some default headers
 	ctx.SetHeader("Server", "web.go", true)
 	tm := time.Now().UTC()
 
-	//ignore errors from ParseForm because it's usually harmless.
-	req.ParseForm()
-	if len(req.Form) > 0 {
-		for k, v := range req.Form {
-			ctx.Params[k] = v[0]
-		}
-	}
-
 	defer s.logRequest(ctx, tm)
 
 	ctx.SetHeader("Date", webTime(tm), true)
@@ -350,64 +574,38 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 		}
 	}
 
-	for i := 0; i < len(s.routes); i++ {
-		route := s.routes[i]
-		cr := route.cr
-		//if the methods don't match, skip this handler (except HEAD can be used in place of GET)
-		if req.Method != route.method && !(req.Method == "HEAD" && route.method == "GET") {
-			continue
-		}
-
-		if !cr.MatchString(requestPath) {
-			continue
-		}
-		match := cr.FindStringSubmatch(requestPath)
-
-		if len(match[0]) != len(requestPath) {
-			continue
-		}
-
-		if route.httpHandler != nil {
-			unused = &route
-			// We can not handle custom http handlers here, give back to the caller.
-			return
-		}
-
-		// set the default content-type
-		ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
-
-		var args []reflect.Value
-		handlerType := route.handler.Type()
-		if requiresContext(handlerType) {
-			args = append(args, reflect.ValueOf(&ctx))
+	if route, match := s.findRoute(req.Method, requestPath); route != nil {
+		ctx.Route = route
+		ctx.setPathParams(route.cr, match)
+		// the request is canceled when the server is closed
+		c, cancel := context.WithCancel(ctx.Request.Context())
+		defer cancel()
+		defer context.AfterFunc(s.context(), cancel)()
+		if route.timeout > 0 {
+			c, cancel = context.WithTimeout(c, route.timeout)
+			defer cancel()
 		}
-		for _, arg := range match[1:] {
-			args = append(args, reflect.ValueOf(arg))
+		ctx.Request = ctx.Request.WithContext(c)
+		if limit := route.bodyLimit(); limit > 0 && ctx.Request.Body != nil {
+			ctx.Request.Body = http.MaxBytesReader(w, ctx.Request.Body, limit)
 		}
-
-		ret, err := s.safelyCall(route.handler, args)
-		if err != nil {
-			//there was an error or panic while calling the handler
-			ctx.Abort(500, "Server Error")
-		}
-		if len(ret) == 0 {
-			return
-		}
-
-		sval := ret[0]
-
-		var content []byte
-
-		if sval.Kind() == reflect.String {
-			content = []byte(sval.String())
-		} else if sval.Kind() == reflect.Slice && sval.Type().Elem().Kind() == reflect.Uint8 {
-			content = sval.Interface().([]byte)
-		}
-		ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
-		_, err = ctx.ResponseWriter.Write(content)
-		if err != nil {
-			ctx.Server.Logger.Println("Error during write: ", err)
+		defer ctx.removeUploads()
+		// ctx.Params is filled for handlers and middleware that are given the
+		// Context, before they run, so the form is parsed for them up front.
+		// A parse error is kept for ctx.ParseForm to report.
+		middleware := route.middleware()
+		if route.withContext || len(middleware) > 0 {
+			ctx.parseForm()
 		}
+		s.safelyCall(&ctx, func() {
+			runMiddleware(&ctx, middleware, func() {
+				if route.httpHandler != nil {
+					route.httpHandler.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+					return
+				}
+				s.callHandler(&ctx, route, match[1:])
+			})
+		})
 		return
 	}
 
@@ -419,8 +617,19 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 			return
 		}
 	}
-	ctx.Abort(404, "Page not found")
-	return
+
+	// the path exists, but not for this method
+	if allowed := s.allowedMethods(requestPath); len(allowed) > 0 {
+		ctx.SetHeader("Allow", strings.Join(allowed, ", "), true)
+		if req.Method == "OPTIONS" && s.Config.AutoOptions {
+			ctx.SetHeader("Content-Length", "0", true)
+			ctx.WriteHeader(200)
+			return
+		}
+		s.methodNotAllowed(&ctx)
+		return
+	}
+	s.notFound(&ctx)
 }
 
 // SetLogger sets the logger for server s
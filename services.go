@@ -0,0 +1,59 @@
+package web
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Provide stores service in s.Env, keyed by the name of its type, so that it
+// is injected into handlers that take a parameter of that type after their
+// path parameters:
+//
+//	s.Provide(db)
+//	s.Get("/users/(.*)", func(ctx *web.Context, id string, db *sql.DB) string { ... })
+//
+// Services are resolved when routes are added, so they must be provided
+// before the routes that use them. Values of the types that path parameters
+// can have, such as strings and numbers, are never injected. A nil service
+// is ignored.
+func (s *Server) Provide(service interface{}) {
+	if service == nil {
+		return
+	}
+	if s.Env == nil {
+		s.Env = map[string]interface{}{}
+	}
+	s.Env[reflect.TypeOf(service).String()] = service
+}
+
+// service finds the value in s.Env to inject into a parameter of type t. A
+// value of exactly that type is used if there is one, otherwise for
+// interface types a value implementing it. Either must be unique.
+func (s *Server) service(t reflect.Type) (reflect.Value, error) {
+	var exact, implementing []reflect.Value
+	for _, v := range s.Env {
+		if v == nil {
+			continue
+		}
+		rv := reflect.ValueOf(v)
+		if rv.Type() == t {
+			exact = append(exact, rv)
+		} else if t.Kind() == reflect.Interface && rv.Type().Implements(t) {
+			implementing = append(implementing, rv)
+		}
+	}
+	candidates := exact
+	if len(candidates) == 0 {
+		candidates = implementing
+	}
+	switch len(candidates) {
+	case 0:
+		return reflect.Value{}, fmt.Errorf("no value of type %v in Env", t)
+	case 1:
+		if candidates[0].Type() != t {
+			return candidates[0].Convert(t), nil
+		}
+		return candidates[0], nil
+	}
+	return reflect.Value{}, fmt.Errorf("several values in Env match type %v", t)
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// staticMount is a source of static files served under a URL prefix.
+type staticMount struct {
+	prefix string
+	fsys   fs.FS
+}
+
+// Static serves the files of fsys, such as an embed.FS or os.DirFS, under the
+// URL path prefix. "/assets/css/site.css" is looked up as "css/site.css" in a
+// file system mounted at "/assets". Mounts are tried in the order they were
+// added, before the files of Config.StaticFS or Config.StaticDir.
+func (s *Server) Static(prefix string, fsys fs.FS) {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix = "/" + prefix
+	}
+	s.static = append(s.static, staticMount{prefix, fsys})
+}
+
+// staticMounts returns the sources of static files in the order they are
+// tried, ending with the root one: Config.StaticFS, Config.StaticDir or the
+// "static" directory in the working directory.
+func (s *Server) staticMounts() []staticMount {
+	root := s.Config.StaticFS
+	if root == nil {
+		dir := s.Config.StaticDir
+		if dir == "" {
+			dir = defaultStaticDir
+		}
+		root = os.DirFS(dir)
+	}
+	return append(s.static[:len(s.static):len(s.static)], staticMount{"", root})
+}
+
+// tryServingFile attempts to serve the static file at the URL path name, and
+// returns true if it did.
+func (s *Server) tryServingFile(name string, req *http.Request, w http.ResponseWriter) bool {
+	for _, mount := range s.staticMounts() {
+		if !strings.HasPrefix(name, mount.prefix+"/") {
+			continue
+		}
+		file := strings.TrimPrefix(path.Clean(name[len(mount.prefix):]), "/")
+		if file == "" {
+			continue
+		}
+		if s.serveFile(w, req, mount.fsys, file, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// precompressed lists the extensions of precompressed siblings of static
+// files, such as "site.css.gz", by content coding, in order of preference.
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// serveFile serves the regular file name of fsys, found at urlPath, with
+// http.ServeContent, which handles Range and conditional requests. A
+// precompressed sibling is served instead if the client accepts its
+// encoding. It returns false if there is no such file.
+func (s *Server) serveFile(w http.ResponseWriter, req *http.Request, fsys fs.FS, name string, urlPath string) bool {
+	info, err := fs.Stat(fsys, name)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	// the encodings the file is available in
+	var offers []string
+	for _, pc := range precompressed {
+		if sib, err := fs.Stat(fsys, name+pc.ext); err == nil && !sib.IsDir() {
+			offers = append(offers, pc.encoding)
+		}
+	}
+	file, encoding := name, ""
+	if len(offers) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if accept := req.Header.Get("Accept-Encoding"); accept != "" {
+			encoding = negotiate(accept, append(offers, "identity"), matchEncodingRange, identityDefault)
+		}
+		for _, pc := range precompressed {
+			if encoding == pc.encoding {
+				file += pc.ext
+			}
+		}
+	}
+
+	f, err := fsys.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if info, err = f.Stat(); err != nil {
+		return false
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if file != name {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	if cc := s.cacheControl(urlPath); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+	}
+	// the content type comes from the name of the uncompressed file
+	http.ServeContent(w, req, path.Base(name), info.ModTime(), content)
+	return true
+}
+
+// ImmutableCacheControl is a Cache-Control value for files that never change,
+// such as fingerprinted assets.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// CachePolicy sets the Cache-Control header of the static files it applies
+// to. The conditions that are set must all hold.
+type CachePolicy struct {
+	// Prefix restricts the policy to URL paths starting with it.
+	Prefix string
+	// Extensions restricts the policy to files with one of the extensions,
+	// such as ".css".
+	Extensions []string
+	// Fingerprinted restricts the policy to files whose names contain a
+	// content hash, such as "app.3f9a2c1e.js" or "app-5XGW2UTC.js".
+	Fingerprinted bool
+	// CacheControl is the value of the header, for instance
+	// "public, max-age=3600" or ImmutableCacheControl.
+	CacheControl string
+}
+
+func (p *CachePolicy) matches(urlPath string) bool {
+	if !strings.HasPrefix(urlPath, p.Prefix) {
+		return false
+	}
+	if p.Fingerprinted && !fingerprinted(path.Base(urlPath)) {
+		return false
+	}
+	if len(p.Extensions) == 0 {
+		return true
+	}
+	ext := path.Ext(urlPath)
+	for _, e := range p.Extensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl returns the Cache-Control value of the first policy in
+// Config.StaticCache that applies to urlPath, or "".
+func (s *Server) cacheControl(urlPath string) string {
+	for i := range s.Config.StaticCache {
+		if p := &s.Config.StaticCache[i]; p.matches(urlPath) {
+			return p.CacheControl
+		}
+	}
+	return ""
+}
+
+// fingerprinted reports whether a file name contains a content hash: a part
+// separated by dots or dashes of at least 8 letters and digits, including a
+// digit, that is either lowercase hex or uppercase.
+func fingerprinted(name string) bool {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '-' })
+	// the last part is the extension
+	for i := 0; i < len(parts)-1; i++ {
+		if isHash(parts[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHash(s string) bool {
+	if len(s) < 8 {
+		return false
+	}
+	var digit, lower, upper bool
+	for _, c := range s {
+		switch {
+		case '0' <= c && c <= '9':
+			digit = true
+		case 'a' <= c && c <= 'f':
+			lower = true
+		case 'A' <= c && c <= 'Z':
+			upper = true
+		default:
+			return false
+		}
+	}
+	return digit && !(lower && upper)
+}
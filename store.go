@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// storeKey is the request context key holding the values set on a Context.
+type storeKey struct{}
+
+// Set stores a value for the rest of the request, for instance so that
+// middleware can pass the authenticated user to handlers. The values are
+// also attached to the request's context, where custom http.Handler routes
+// can read them with RequestValue.
+func (ctx *Context) Set(key string, value interface{}) {
+	if ctx.store == nil {
+		ctx.store = map[string]interface{}{}
+		ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), storeKey{}, ctx.store))
+	}
+	ctx.store[key] = value
+}
+
+// Get returns the value stored under key with Set, and whether there was one.
+func (ctx *Context) Get(key string) (interface{}, bool) {
+	value, ok := ctx.store[key]
+	return value, ok
+}
+
+// MustGet returns the value stored under key with Set, and panics if there
+// is none.
+func (ctx *Context) MustGet(key string) interface{} {
+	value, ok := ctx.store[key]
+	if !ok {
+		panic(fmt.Sprintf("web: no value for key %q", key))
+	}
+	return value
+}
+
+// GetAs returns the value stored under key with Set if it has type T.
+//
+//	user, ok := web.GetAs[*User](ctx, "user")
+func GetAs[T any](ctx *Context, key string) (T, bool) {
+	value, ok := ctx.store[key].(T)
+	return value, ok
+}
+
+// RequestValue returns the value stored under key with Context.Set while
+// handling req. It lets custom http.Handler routes and net/http middleware
+// read values set by web.go middleware.
+func RequestValue(req *http.Request, key string) (interface{}, bool) {
+	store, _ := req.Context().Value(storeKey{}).(map[string]interface{})
+	value, ok := store[key]
+	return value, ok
+}
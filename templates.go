@@ -0,0 +1,184 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TemplateConfig configures the html/template templates rendered with
+// ctx.Render.
+//
+// Templates are named after their path relative to the root, without the
+// extension: "users/show.html" is rendered as "users/show". Files under
+// "layouts/" and "partials/" are shared: they are parsed along with every
+// other template, so pages can call partials with {{template "partials/nav" .}}
+// and fill in the blocks of a layout with {{define "content"}}.
+type TemplateConfig struct {
+	// Dir is the directory holding the templates, used if FS is nil.
+	Dir string
+	// FS holds the templates, for instance an embed.FS.
+	FS fs.FS
+	// Ext is the extension of template files, ".html" by default.
+	Ext string
+	// Layout is the name of the layout that pages are rendered into, such
+	// as "layouts/base". Pages are rendered on their own if it is empty.
+	Layout string
+	// Funcs are added to the functions available in templates, which
+	// include URLFor to build the URL of a named route.
+	Funcs template.FuncMap
+	// Reload reparses the templates when their files change, which is
+	// convenient during development.
+	Reload bool
+}
+
+// templateSet holds the parsed templates of a server, one per page.
+type templateSet struct {
+	pages map[string]*template.Template
+	// describes the files the templates were parsed from, to detect changes
+	version string
+}
+
+// Render executes the template called name with data, within the layout of
+// the template configuration, and writes the result as HTML with the given
+// status. Nothing is written if the template fails, and the error is
+// returned.
+func (ctx *Context) Render(status int, name string, data interface{}) error {
+	set, err := ctx.Server.loadTemplates()
+	if err != nil {
+		return err
+	}
+	t, ok := set.pages[name]
+	if !ok {
+		return fmt.Errorf("No template named %q", name)
+	}
+	var buf bytes.Buffer
+	if layout := ctx.Server.Templates.Layout; layout != "" {
+		err = t.ExecuteTemplate(&buf, layout, data)
+	} else {
+		err = t.Execute(&buf, data)
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Blob(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// loadTemplates returns the parsed templates of the server, parsing them the
+// first time, or again if they changed and reloading is enabled.
+func (s *Server) loadTemplates() (*templateSet, error) {
+	config := s.Templates
+	if config == nil {
+		return nil, fmt.Errorf("Server has no templates configured")
+	}
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+
+	set := s.templates
+	if set != nil && !config.Reload {
+		return set, nil
+	}
+	fsys, files, version, err := config.files()
+	if err != nil {
+		return nil, err
+	}
+	if set != nil && set.version == version {
+		return set, nil
+	}
+	set, err = s.parseTemplates(fsys, files)
+	if err != nil {
+		return nil, err
+	}
+	set.version = version
+	s.templates = set
+	return set, nil
+}
+
+// files lists the template files, sorted, along with a description of their
+// sizes and modification times.
+func (config *TemplateConfig) files() (fs.FS, []string, string, error) {
+	fsys := config.FS
+	if fsys == nil {
+		fsys = os.DirFS(config.Dir)
+	}
+	ext := config.Ext
+	if ext == "" {
+		ext = ".html"
+	}
+	var files []string
+	var version strings.Builder
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ext {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, name)
+		fmt.Fprintf(&version, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	sort.Strings(files)
+	return fsys, files, version.String(), err
+}
+
+// parseTemplates parses every page along with the shared layouts and
+// partials.
+func (s *Server) parseTemplates(fsys fs.FS, files []string) (*templateSet, error) {
+	funcs := template.FuncMap{
+		"URLFor": s.URLFor,
+	}
+	for name, f := range s.Templates.Funcs {
+		funcs[name] = f
+	}
+
+	var shared, pages []string
+	for _, file := range files {
+		if strings.HasPrefix(file, "layouts/") || strings.HasPrefix(file, "partials/") {
+			shared = append(shared, file)
+		} else {
+			pages = append(pages, file)
+		}
+	}
+
+	set := &templateSet{pages: map[string]*template.Template{}}
+	for _, page := range pages {
+		t := template.New(templateName(page)).Funcs(funcs)
+		for _, file := range shared {
+			if err := parseTemplateFile(t, fsys, file); err != nil {
+				return nil, err
+			}
+		}
+		// the page is parsed last, so its definitions replace the defaults
+		// of the layouts
+		if err := parseTemplateFile(t, fsys, page); err != nil {
+			return nil, err
+		}
+		set.pages[templateName(page)] = t
+	}
+	return set, nil
+}
+
+// parseTemplateFile adds the template in file to the set of t.
+func parseTemplateFile(t *template.Template, fsys fs.FS, file string) error {
+	content, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	if name := templateName(file); name != t.Name() {
+		t = t.New(name)
+	}
+	_, err = t.Parse(string(content))
+	return err
+}
+
+// templateName is the name of the template in a file.
+func templateName(file string) string {
+	return strings.TrimSuffix(file, path.Ext(file))
+}
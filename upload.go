@@ -0,0 +1,145 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultMultipartMemory is how much of a multipart body is kept in memory
+// when it is parsed, the rest is stored in temporary files.
+const defaultMultipartMemory = 32 << 20
+
+// parseMultipart parses a multipart request body the first time it is
+// called, keeping up to Config.MultipartMemory bytes of it in memory.
+func (ctx *Context) parseMultipart() error {
+	if ctx.Request.MultipartForm != nil {
+		return nil
+	}
+	memory := int64(defaultMultipartMemory)
+	if ctx.Server != nil && ctx.Server.Config != nil && ctx.Server.Config.MultipartMemory > 0 {
+		memory = ctx.Server.Config.MultipartMemory
+	}
+	if err := ctx.Request.ParseMultipartForm(memory); err != nil {
+		return bodyError(err)
+	}
+	return nil
+}
+
+// FormFile returns the first file uploaded under name in a multipart request.
+// Files over Config.MaxFileBytes are reported with a status of 413, and files
+// whose content doesn't match Config.AllowedFileTypes with 415, after the
+// whole body has been parsed. A missing file is a 400 error.
+func (ctx *Context) FormFile(name string) (*multipart.FileHeader, error) {
+	files, err := ctx.FormFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, &HTTPError{Code: 400, Message: fmt.Sprintf("Missing file %q", name)}
+	}
+	return files[0], nil
+}
+
+// FormFiles returns every file uploaded under name in a multipart request,
+// checked like in FormFile. It returns no files and no error if there are
+// none.
+func (ctx *Context) FormFiles(name string) ([]*multipart.FileHeader, error) {
+	if err := ctx.parseMultipart(); err != nil {
+		return nil, err
+	}
+	files := ctx.Request.MultipartForm.File[name]
+	for _, fh := range files {
+		if err := ctx.checkFile(fh); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// checkFile checks an uploaded file against the size and type limits of the
+// server.
+func (ctx *Context) checkFile(fh *multipart.FileHeader) error {
+	if ctx.Server == nil || ctx.Server.Config == nil {
+		return nil
+	}
+	config := ctx.Server.Config
+	if config.MaxFileBytes > 0 && fh.Size > config.MaxFileBytes {
+		return &HTTPError{Code: 413, Message: fmt.Sprintf("File %q is too large", fh.Filename)}
+	}
+	if len(config.AllowedFileTypes) == 0 {
+		return nil
+	}
+	contentType, err := sniffFile(fh)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range config.AllowedFileTypes {
+		if matchMediaType(allowed, contentType) {
+			return nil
+		}
+	}
+	return &HTTPError{Code: 415, Message: fmt.Sprintf("File %q has unsupported type %s", fh.Filename, contentType)}
+}
+
+// sniffFile detects the media type of an uploaded file from its content,
+// without any parameters.
+func sniffFile(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	contentType := http.DetectContentType(buf[:n])
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType, nil
+}
+
+// matchMediaType reports whether the media type t matches pattern, which may
+// end in a wildcard such as "image/*".
+func matchMediaType(pattern string, t string) bool {
+	if pattern == "*/*" || pattern == t {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(t, pattern[:len(pattern)-1])
+	}
+	return false
+}
+
+// SaveUploadedFile writes the content of an uploaded file to the file dst,
+// which is created or truncated.
+func (ctx *Context) SaveUploadedFile(fh *multipart.FileHeader, dst string) error {
+	src, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeUploads deletes the temporary files of a parsed multipart form.
+func (ctx *Context) removeUploads() {
+	if form := ctx.Request.MultipartForm; form != nil {
+		form.RemoveAll()
+	}
+}
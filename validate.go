@@ -0,0 +1,227 @@
+package web
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a struct field that failed validation.
+type FieldError struct {
+	// Field is the name of the field as the client knows it: the name from
+	// its `json` or `form` tag, or the Go field name. Nested fields are
+	// joined with dots.
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, such as "required" or "min".
+	Rule string `json:"rule"`
+	// Param is the parameter of the rule, such as 3 in "min=3".
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors is the list of fields that failed validation. Returned
+// from a handler, it produces a 422 response.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// StatusCode returns 422.
+func (errs ValidationErrors) StatusCode() int {
+	return 422
+}
+
+// Validate checks the fields of the struct v, or pointed to by v, against
+// the rules in their `validate` tags, separated by commas:
+//
+//	required     the field must not be the zero value
+//	min=n max=n  bounds on numbers, or on the length of strings, slices and maps
+//	email        the field must be an email address
+//	oneof=a b c  the field must be one of the space separated values
+//	regexp=re    the field must match the regular expression; as it may
+//	             contain commas, it must be the last rule
+//
+// Fields that are empty and not required are not checked further. Nested
+// structs are validated as well. It returns nil or ValidationErrors.
+func Validate(v interface{}) error {
+	var errs ValidationErrors
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Struct {
+		validateStruct(rv, "", &errs)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs *ValidationErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		fv := rv.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			validateStruct(fv, prefix, errs)
+			continue
+		}
+		name := prefix + fieldName(field)
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			validateField(fv, name, tag, errs)
+		}
+
+		// descend into nested structs
+		fv = reflect.Indirect(fv)
+		switch fv.Kind() {
+		case reflect.Struct:
+			validateStruct(fv, name+".", errs)
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < fv.Len(); j++ {
+				if elem := reflect.Indirect(fv.Index(j)); elem.Kind() == reflect.Struct {
+					validateStruct(elem, fmt.Sprintf("%s[%d].", name, j), errs)
+				}
+			}
+		}
+	}
+}
+
+// fieldName returns the name of a field used in error messages.
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name := strings.Split(field.Tag.Get(key), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func validateField(fv reflect.Value, name string, tag string, errs *ValidationErrors) {
+	for tag != "" {
+		var rule string
+		if strings.HasPrefix(tag, "regexp=") {
+			rule, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			rule, tag = tag, ""
+		}
+		param := ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			rule, param = rule[:i], rule[i+1:]
+		}
+
+		if rule == "required" {
+			if fv.IsZero() {
+				*errs = append(*errs, FieldError{Field: name, Rule: rule, Message: name + " is required"})
+				return
+			}
+			continue
+		}
+		// optional fields are only checked when set
+		if fv.IsZero() {
+			return
+		}
+		if msg := checkRule(reflect.Indirect(fv), rule, param); msg != "" {
+			*errs = append(*errs, FieldError{Field: name, Rule: rule, Param: param, Message: name + " " + msg})
+		}
+	}
+}
+
+// checkRule returns why v breaks the rule, or "" if it doesn't.
+func checkRule(v reflect.Value, rule string, param string) string {
+	switch rule {
+	case "min", "max":
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return fmt.Sprintf("has an invalid %s rule %q", rule, param)
+		}
+		n, isLength, ok := measure(v)
+		if !ok {
+			return fmt.Sprintf("cannot be checked with %s", rule)
+		}
+		switch {
+		case rule == "min" && n < bound && isLength:
+			return fmt.Sprintf("must have a length of at least %s", param)
+		case rule == "min" && n < bound:
+			return fmt.Sprintf("must be at least %s", param)
+		case rule == "max" && n > bound && isLength:
+			return fmt.Sprintf("must have a length of at most %s", param)
+		case rule == "max" && n > bound:
+			return fmt.Sprintf("must be at most %s", param)
+		}
+	case "email":
+		addr, err := mail.ParseAddress(fmt.Sprint(v.Interface()))
+		if err != nil || addr.Address != fmt.Sprint(v.Interface()) {
+			return "must be a valid email address"
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, option := range strings.Fields(param) {
+			if s == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(strings.Fields(param), ", "))
+	case "regexp":
+		re, err := compileRule(param)
+		if err != nil {
+			return fmt.Sprintf("has an invalid regexp rule %q", param)
+		}
+		if v.Kind() != reflect.String || !re.MatchString(v.String()) {
+			return fmt.Sprintf("must match %s", param)
+		}
+	default:
+		return fmt.Sprintf("has an unknown validation rule %q", rule)
+	}
+	return ""
+}
+
+// measure returns the number that min and max compare against: the value
+// of numbers and the length of everything else.
+func measure(v reflect.Value) (n float64, isLength bool, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
+
+// rule expressions are compiled once
+var ruleRegexps sync.Map
+
+func compileRule(expr string) (*regexp.Regexp, error) {
+	if re, ok := ruleRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	ruleRegexps.Store(expr, re)
+	return re, nil
+}
@@ -3,26 +3,85 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
 	"golang.org/x/net/websocket"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // A Context object is created for every incoming HTTP request, and is
 // passed to handlers as an optional first argument. It provides information
 // about the request, including the http.Request object, the GET and POST params,
-// and acts as a Writer for the response.
+// and acts as a Writer for the response. It also implements context.Context,
+// backed by the context of the request, so handlers can stop working when
+// the request is canceled.
 type Context struct {
 	Request *http.Request
 	Params  map[string]string
-	Server  *Server
+	// PathParams holds the named parameters captured by the route, such as
+	// `id` in "/users/:id". It is nil if the route has no named parameters.
+	PathParams map[string]string
+	// Route is the route that matched the request, or nil if none did.
+	Route  *Route
+	Server *Server
 	http.ResponseWriter
+	// values set with Set
+	store map[string]interface{}
+	// the parsed query string
+	query url.Values
+	// whether the form has been parsed into Params, and how that went
+	formParsed bool
+	formErr    error
+}
+
+// setPathParams fills ctx.PathParams from the named groups of cr.
+func (ctx *Context) setPathParams(cr *regexp.Regexp, match []string) {
+	for i, name := range cr.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if ctx.PathParams == nil {
+			ctx.PathParams = map[string]string{}
+		}
+		ctx.PathParams[name] = match[i]
+	}
+}
+
+// Deadline implements context.Context, using the context of the request.
+func (ctx *Context) Deadline() (time.Time, bool) {
+	return ctx.Request.Context().Deadline()
+}
+
+// Done implements context.Context. The channel is closed when the client
+// goes away, the server is closed with Close or the route's timeout expires.
+func (ctx *Context) Done() <-chan struct{} {
+	return ctx.Request.Context().Done()
+}
+
+// Err implements context.Context, using the context of the request.
+func (ctx *Context) Err() error {
+	return ctx.Request.Context().Err()
+}
+
+// Value implements context.Context. String keys find the values stored
+// with Set, other keys are looked up in the context of the request.
+func (ctx *Context) Value(key interface{}) interface{} {
+	if k, ok := key.(string); ok {
+		if value, ok := ctx.store[k]; ok {
+			return value
+		}
+	}
+	return ctx.Request.Context().Value(key)
 }
 
 // WriteString writes string data into the response object.
@@ -112,24 +171,16 @@ func (ctx *Context) SetCookie(cookie *http.Cookie) {
 // small optimization: cache the context type instead of repeteadly calling reflect.Typeof
 var contextType reflect.Type
 
-var defaultStaticDirs []string
+var stdContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// defaultStaticDir is the directory static files are served from if
+// Config.StaticDir isn't set.
+var defaultStaticDir string
 
 func init() {
 	contextType = reflect.TypeOf(Context{})
-	//find the location of the exe file
 	wd, _ := os.Getwd()
-	arg0 := path.Clean(os.Args[0])
-	var exeFile string
-	if strings.HasPrefix(arg0, "/") {
-		exeFile = arg0
-	} else {
-		//TODO for robustness, search each directory in $PATH
-		exeFile = path.Join(wd, arg0)
-	}
-	parent, _ := path.Split(exeFile)
-	defaultStaticDirs = append(defaultStaticDirs, path.Join(parent, "static"))
-	defaultStaticDirs = append(defaultStaticDirs, path.Join(wd, "static"))
-	return
+	defaultStaticDir = path.Join(wd, "static")
 }
 
 // Process invokes the main server's routing system.
@@ -163,38 +214,66 @@ func Close() {
 }
 
 // Get adds a handler for the 'GET' http method in the main server.
-func Get(route string, handler interface{}) {
-	mainServer.Get(route, handler)
+func Get(route string, handler interface{}) *Route {
+	return mainServer.Get(route, handler)
 }
 
 // Post adds a handler for the 'POST' http method in the main server.
-func Post(route string, handler interface{}) {
-	mainServer.addRoute(route, "POST", handler)
+func Post(route string, handler interface{}) *Route {
+	return mainServer.addRoute(route, "POST", handler)
 }
 
 // Put adds a handler for the 'PUT' http method in the main server.
-func Put(route string, handler interface{}) {
-	mainServer.addRoute(route, "PUT", handler)
+func Put(route string, handler interface{}) *Route {
+	return mainServer.addRoute(route, "PUT", handler)
 }
 
 // Delete adds a handler for the 'DELETE' http method in the main server.
-func Delete(route string, handler interface{}) {
-	mainServer.addRoute(route, "DELETE", handler)
+func Delete(route string, handler interface{}) *Route {
+	return mainServer.addRoute(route, "DELETE", handler)
 }
 
 // Match adds a handler for an arbitrary http method in the main server.
-func Match(method string, route string, handler interface{}) {
-	mainServer.addRoute(route, method, handler)
+func Match(method string, route string, handler interface{}) *Route {
+	return mainServer.addRoute(route, method, handler)
 }
 
 // Add a custom http.Handler. Will have no effect when running as FCGI or SCGI.
-func Handle(route string, method string, httpHandler http.Handler) {
-	mainServer.Handle(route, method, httpHandler)
+func Handle(route string, method string, httpHandler http.Handler) *Route {
+	return mainServer.Handle(route, method, httpHandler)
 }
 
 //Adds a handler for websockets. Only for webserver mode. Will have no effect when running as FCGI or SCGI.
-func Websocket(route string, httpHandler websocket.Handler) {
-	mainServer.Websocket(route, httpHandler)
+func Websocket(route string, httpHandler websocket.Handler) *Route {
+	return mainServer.Websocket(route, httpHandler)
+}
+
+// Provide adds a service to the main server, to be injected into handlers by
+// type.
+func Provide(service interface{}) {
+	mainServer.Provide(service)
+}
+
+// Use adds middleware that runs around every route of the main server.
+func Use(middleware ...interface{}) {
+	mainServer.Use(middleware...)
+}
+
+// URLFor builds the URL of a named route of the main server.
+func URLFor(name string, args ...interface{}) (string, error) {
+	return mainServer.URLFor(name, args...)
+}
+
+// Group returns a route group of the main server whose routes are all
+// prefixed with `prefix`.
+func Group(prefix string) *RouteGroup {
+	return mainServer.Group(prefix)
+}
+
+// Static serves the files of fsys under the URL path prefix in the main
+// server.
+func Static(prefix string, fsys fs.FS) {
+	mainServer.Static(prefix, fsys)
 }
 
 // SetLogger sets the logger for the main server.
@@ -202,6 +281,12 @@ func SetLogger(logger *log.Logger) {
 	mainServer.Logger = logger
 }
 
+// SetTemplates sets the configuration of the templates rendered by the main
+// server.
+func SetTemplates(config *TemplateConfig) {
+	mainServer.Templates = config
+}
+
 // Config is the configuration of the main server.
 var Config = &ServerConfig{
 	RecoverPanic: true,
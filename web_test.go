@@ -2,19 +2,27 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func init() {
@@ -109,6 +117,17 @@ func getTestResponse(method string, path string, body string, headers map[string
 	return buildTestResponse(&buf)
 }
 
+func processTestRequest(s *Server, method string, path string, body string, headers map[string][]string) *testResponse {
+	req := buildTestRequest(method, path, body, headers, nil)
+	var buf bytes.Buffer
+	iob := ioBuffer{input: nil, output: &buf}
+	c := scgiConn{wroteHeaders: false, req: req, headers: make(map[string][]string), fd: &iob}
+	s.Process(&c, req)
+	// like net/http, send the headers if the handler wrote nothing
+	c.WriteHeader(200)
+	return buildTestResponse(&buf)
+}
+
 func testGet(path string, headers map[string]string) *testResponse {
 	var header http.Header
 	for k, v := range headers {
@@ -117,6 +136,9 @@ func testGet(path string, headers map[string]string) *testResponse {
 	return getTestResponse("GET", path, "", header, nil)
 }
 
+// the Accept header sent by browsers
+const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
 type Test struct {
 	method         string
 	path           string
@@ -191,6 +213,21 @@ func init() {
 		return data
 	})
 
+	Get("/serialize/struct", func() interface{} {
+		return struct {
+			XMLName struct{} `json:"-" xml:"point"`
+			X       int      `json:"x" xml:"x"`
+			Y       int      `json:"y" xml:"y"`
+		}{X: 1, Y: 2}
+	})
+	Get("/serialize/map", func() map[string]int { return map[string]int{"a": 1} })
+	Get("/serialize/slice", func() ([]string, error) { return []string{"a", "b"}, nil })
+	Get("/serialize/unencodable", func() interface{} {
+		return struct {
+			Tags map[string]int `json:"tags"`
+		}{map[string]int{"a": 1}}
+	})
+
 	Post("/parsejson", func(ctx *Context) string {
 		var tmp = struct {
 			A string
@@ -211,6 +248,12 @@ func init() {
 		return ""
 	})
 
+	Get("/users/:id/posts/:slug", func(ctx *Context, id string, slug string) string {
+		return id + " " + slug + " " + ctx.PathParams["id"] + " " + ctx.PathParams["slug"]
+	})
+
+	Get("/items/{id:[0-9]{2,4}}", func(ctx *Context, id string) string { return ctx.PathParams["id"] })
+
 	Get("/authorization", func(ctx *Context) string {
 		user, pass, err := ctx.GetBasicAuth()
 		if err != nil {
@@ -242,6 +285,7 @@ var tests = []Test{
 	{"GET", "/error/notfound/notfound", nil, "", 404, "notfound"},
 	{"GET", "/doesnotexist", nil, "", 404, "Page not found"},
 	{"POST", "/doesnotexist", nil, "", 404, "Page not found"},
+	{"POST", "/echo/hello", nil, "", 405, "Method not allowed"},
 	{"GET", "/error/code/500", nil, "", 500, http.StatusText(500)},
 	{"POST", "/posterror/code/410/failedrequest", nil, "", 410, "failedrequest"},
 	{"GET", "/getparam?a=abcd", nil, "", 200, "abcd"},
@@ -250,7 +294,22 @@ var tests = []Test{
 	{"GET", "/panic", nil, "", 500, "Server Error"},
 	{"GET", "/json?a=1&b=2", nil, "", 200, `{"a":"1","b":"2"}`},
 	{"GET", "/jsonbytes?a=1&b=2", nil, "", 200, `{"a":"1","b":"2"}`},
+	{"GET", "/serialize/struct", nil, "", 200, `{"x":1,"y":2}`},
+	{"GET", "/serialize/struct", map[string][]string{"Accept": {"application/xml"}}, "", 200, `<point><x>1</x><y>2</y></point>`},
+	{"GET", "/serialize/struct", map[string][]string{"Accept": {"application/json;q=0.5, text/xml"}}, "", 200, `<point><x>1</x><y>2</y></point>`},
+	{"GET", "/serialize/map", map[string][]string{"Accept": {"text/html, */*"}}, "", 200, `{"a":1}`},
+	{"GET", "/serialize/slice", nil, "", 200, `["a","b"]`},
+	{"GET", "/serialize/map", map[string][]string{"Accept": {browserAccept}}, "", 200, `{"a":1}`},
+	{"GET", "/serialize/slice", map[string][]string{"Accept": {browserAccept}}, "", 200, `["a","b"]`},
+	{"GET", "/serialize/struct", map[string][]string{"Accept": {browserAccept}}, "", 200, `{"x":1,"y":2}`},
+	{"GET", "/serialize/unencodable", map[string][]string{"Accept": {"application/xml"}}, "", 500, "Server Error"},
+	{"GET", "/serialize/unencodable", map[string][]string{"Accept": {browserAccept}}, "", 200, `{"tags":{"a":1}}`},
 	{"POST", "/parsejson", map[string][]string{"Content-Type": {"application/json"}}, `{"a":"hello", "b":"world"}`, 200, "hello world"},
+	{"GET", "/users/12/posts/hello", nil, "", 200, "12 hello 12 hello"},
+	{"GET", "/users/12/posts/hello/more", nil, "", 404, "Page not found"},
+	{"GET", "/items/123", nil, "", 200, "123"},
+	{"GET", "/items/1", nil, "", 404, "Page not found"},
+	{"GET", "/items/abc", nil, "", 404, "Page not found"},
 	//{"GET", "/testenv", "", 200, "hello world"},
 	{"GET", "/authorization", map[string][]string{"Authorization": {BuildBasicAuthCredentials("foo", "bar")}}, "", 200, "foobar"},
 	{"GET", "/authorization", nil, "", 200, "fail"},
@@ -276,11 +335,12 @@ func buildTestRequest(method string, path string, body string, headers map[strin
 	}
 
 	req := http.Request{Method: method,
-		URL:    url_,
-		Proto:  proto,
-		Host:   host,
-		Header: http.Header(headers),
-		Body:   ioutil.NopCloser(bytes.NewBufferString(body)),
+		URL:           url_,
+		Proto:         proto,
+		Host:          host,
+		Header:        http.Header(headers),
+		Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
 	}
 
 	for _, cookie := range cookies {
@@ -528,6 +588,1381 @@ func TestOptions(t *testing.T) {
 	}
 }
 
+func TestMethodNotAllowed(t *testing.T) {
+	resp := getTestResponse("PUT", "/error/forbidden", "", nil, nil)
+	if resp.statusCode != 405 {
+		t.Fatalf("expected status 405 got %d", resp.statusCode)
+	}
+	if allow := resp.headers["Allow"]; len(allow) != 1 || allow[0] != "GET, HEAD, POST" {
+		t.Fatalf("incorrect Allow header %#v", allow)
+	}
+}
+
+func TestAutoOptions(t *testing.T) {
+	s := NewServer()
+	s.Config = &ServerConfig{AutoOptions: true}
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/users/:id", func(id string) string { return id })
+	s.Put("/users/(.*)", func(id string) string { return id })
+	s.Match("OPTIONS", "/custom", func() string { return "custom" })
+
+	resp := processTestRequest(s, "OPTIONS", "/users/1", "", nil)
+	if resp.statusCode != 200 {
+		t.Fatalf("expected status 200 got %d", resp.statusCode)
+	}
+	if allow := resp.headers["Allow"]; len(allow) != 1 || allow[0] != "GET, HEAD, OPTIONS, PUT" {
+		t.Fatalf("incorrect Allow header %#v", allow)
+	}
+
+	resp = processTestRequest(s, "OPTIONS", "/custom", "", nil)
+	if resp.body != "custom" {
+		t.Fatalf("an OPTIONS route should take precedence, got %q", resp.body)
+	}
+
+	resp = processTestRequest(s, "OPTIONS", "/missing", "", nil)
+	if resp.statusCode != 404 {
+		t.Fatalf("expected status 404 got %d", resp.statusCode)
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	var trace []string
+	tracer := func(name string) Middleware {
+		return func(ctx *Context, next func()) {
+			trace = append(trace, name)
+			next()
+		}
+	}
+
+	api := s.Group("/api/v1.0")
+	api.Use(tracer("api"))
+	api.Get("/echo/(.*)", func(v string) string { return v })
+	users := api.Group("/users/:id")
+	users.Use(tracer("users"))
+	users.Get("/posts/:slug", func(ctx *Context, id, slug string) string { return id + " " + slug })
+	users.Handle("/raw", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "raw")
+	}))
+	admin := api.Group("/admin")
+	admin.Use(func(ctx *Context, next func()) {
+		ctx.Forbidden()
+	})
+	admin.Get("/", func() string { return "admin" })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+		trace  string
+	}{
+		{"/api/v1.0/echo/a", 200, "a", "api"},
+		{"/api/v1x0/echo/a", 404, "Page not found", ""},
+		{"/api/v1.0/users/1/posts/b", 200, "1 b", "api,users"},
+		{"/api/v1.0/users/1/raw", 200, "raw", "api,users"},
+		{"/api/v1.0/admin/", 403, "", "api"},
+	}
+	for _, test := range tests {
+		trace = nil
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+		if strings.Join(trace, ",") != test.trace {
+			t.Fatalf("GET %v expected middleware %q got %q", test.path, test.trace, strings.Join(trace, ","))
+		}
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(func(ctx *Context, next func()) {
+		if ctx.Params["deny"] != "" {
+			ctx.Abort(403, "denied")
+			return
+		}
+		ctx.SetHeader("X-Outer", "1", true)
+		next()
+	})
+	s.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Inner", req.URL.Path)
+			h.ServeHTTP(w, req)
+		})
+	})
+	g := s.Group("/g")
+	g.Use(Middleware(func(ctx *Context, next func()) {
+		ctx.SetHeader("X-Group", "1", true)
+		next()
+	}))
+	s.Get("/echo/(.*)", func(v string) string { return v })
+	s.Handle("/raw", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "raw")
+	}))
+	g.Get("/echo/(.*)", func(v string) string { return v })
+
+	tests := []struct {
+		path    string
+		body    string
+		headers []string
+	}{
+		{"/echo/a", "a", []string{"X-Outer", "X-Inner"}},
+		{"/raw", "raw", []string{"X-Outer", "X-Inner"}},
+		{"/g/echo/b", "b", []string{"X-Outer", "X-Inner", "X-Group"}},
+		{"/echo/a?deny=1", "denied", nil},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.body != test.body {
+			t.Fatalf("GET %v expected %q got %q", test.path, test.body, resp.body)
+		}
+		for _, h := range test.headers {
+			if len(resp.headers[h]) == 0 {
+				t.Fatalf("GET %v expected header %v to be set", test.path, h)
+			}
+		}
+		if test.headers == nil && len(resp.headers["X-Inner"]) > 0 {
+			t.Fatalf("GET %v should have stopped at the first middleware", test.path)
+		}
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	var trace []string
+	s.Use(func(ctx *Context, next func()) {
+		trace = append(trace, "server")
+		next()
+	})
+	requireRole := func(ctx *Context, next func()) {
+		trace = append(trace, "route")
+		if ctx.Route.Meta("role") != ctx.Params["role"] {
+			ctx.Forbidden()
+			return
+		}
+		next()
+	}
+	g := s.Group("/admin")
+	g.Use(func(ctx *Context, next func()) {
+		trace = append(trace, "group")
+		next()
+	})
+	g.Get("/", func() string { return "admin" }).Use(requireRole).SetMeta("role", "admin")
+	s.Get("/public", func() string { return "public" })
+
+	tests := []struct {
+		path   string
+		status int
+		trace  string
+	}{
+		{"/admin/?role=admin", 200, "server,group,route"},
+		{"/admin/?role=user", 403, "server,group,route"},
+		{"/public", 200, "server"},
+	}
+	for _, test := range tests {
+		trace = nil
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status {
+			t.Fatalf("GET %v expected status %d got %d", test.path, test.status, resp.statusCode)
+		}
+		if strings.Join(trace, ",") != test.trace {
+			t.Fatalf("GET %v expected middleware %q got %q", test.path, test.trace, strings.Join(trace, ","))
+		}
+	}
+}
+
+func TestErrorHandlers(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.NotFoundHandler = func(ctx *Context) {
+		ctx.ContentType("json")
+		ctx.WriteHeader(404)
+		ctx.WriteString(`{"error":"not found"}`)
+	}
+	s.MethodNotAllowedHandler = func(ctx *Context) {
+		ctx.Abort(405, "allowed: "+ctx.Header().Get("Allow"))
+	}
+	var recovered interface{}
+	s.ErrorHandler = func(ctx *Context, err error) {
+		if perr, ok := err.(*PanicError); ok {
+			recovered = perr.Value
+		}
+		ctx.Abort(500, "custom: "+err.Error())
+	}
+	s.Get("/panic", func() string { panic("boom") })
+	s.Use(func(ctx *Context, next func()) {
+		if ctx.Params["fail"] != "" {
+			panic("middleware")
+		}
+		next()
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/missing", 404, `{"error":"not found"}`},
+		{"POST", "/panic", 405, "allowed: GET, HEAD"},
+		{"GET", "/panic", 500, "custom: Handler crashed with error boom"},
+		{"GET", "/panic?fail=1", 500, "custom: Handler crashed with error middleware"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, test.method, test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("%v %v expected %d %q got %d %q", test.method, test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+	if recovered != "middleware" {
+		t.Fatalf("the error handler should receive the recovered value, got %v", recovered)
+	}
+}
+
+type teapotError struct{}
+
+func (teapotError) Error() string   { return "short and stout" }
+func (teapotError) StatusCode() int { return 418 }
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&HTTPError{Code: 404}, 404},
+		{fmt.Errorf("loading: %w", &HTTPError{Code: 403}), 403},
+		{errors.New("failed"), 500},
+		{&HTTPError{}, 500},
+		{&HTTPError{Code: 1000}, 500},
+	}
+	for _, test := range tests {
+		if status := ErrorStatus(test.err); status != test.status {
+			t.Fatalf("ErrorStatus(%#v) expected %d got %d", test.err, test.status, status)
+		}
+	}
+
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/zero", func() error { return &HTTPError{} })
+	if resp := processTestRequest(s, "GET", "/zero", "", nil); resp.statusCode != 500 || resp.body != "Server Error" {
+		t.Fatalf("expected a zero HTTPError to be a 500, got %d %q", resp.statusCode, resp.body)
+	}
+}
+
+func TestErrorMessagesArePlainText(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{AllowedFileTypes: []string{"image/*"}}
+	s.Get("/num/(.*)", func(n int) string { return "ok" })
+	s.Get("/query", func(ctx *Context) (string, error) {
+		_, err := ctx.ParamInt("x", 0)
+		return "ok", err
+	})
+	s.Post("/upload", func(ctx *Context) (string, error) {
+		_, err := ctx.FormFile("file")
+		return "ok", err
+	})
+
+	payload := "<img src=x onerror=alert(1)>"
+	body, headers := multipartBody(payload, "text")
+	tests := []struct {
+		method  string
+		path    string
+		body    string
+		headers map[string][]string
+		status  int
+	}{
+		{"GET", "/num/" + url.PathEscape(payload), "", nil, 400},
+		{"GET", "/query?x=" + url.QueryEscape(payload), "", nil, 400},
+		{"POST", "/upload", body, headers, 415},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, test.method, test.path, test.body, test.headers)
+		if resp.statusCode != test.status || !strings.Contains(resp.body, payload) {
+			t.Fatalf("%s %s expected %d with the payload got %d %q", test.method, test.path, test.status, resp.statusCode, resp.body)
+		}
+		if ctype := resp.headers["Content-Type"]; len(ctype) != 1 || ctype[0] != "text/plain; charset=utf-8" {
+			t.Fatalf("%s %s expected a plain text error, got %q", test.method, test.path, ctype)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/string/(.*)", func(v string) (string, error) {
+		if v == "fail" {
+			return "ignored", errors.New("failed")
+		}
+		return v, nil
+	})
+	s.Get("/bytes", func() ([]byte, error) { return nil, &HTTPError{Code: 404, Message: "no such thing"} })
+	s.Get("/error/(.*)", func(ctx *Context, v string) error {
+		switch v {
+		case "teapot":
+			return fmt.Errorf("wrapped: %w", teapotError{})
+		case "nil":
+			ctx.WriteString("ok")
+		}
+		return nil
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/string/a", 200, "a"},
+		{"/string/fail", 500, "Server Error"},
+		{"/bytes", 404, "no such thing"},
+		{"/error/teapot", 418, "wrapped: short and stout"},
+		{"/error/nil", 200, "ok"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestSerializedContentType(t *testing.T) {
+	resp := getTestResponse("GET", "/serialize/map", "", nil, nil)
+	if ct := resp.headers["Content-Type"]; len(ct) != 1 || ct[0] != "application/json; charset=utf-8" {
+		t.Fatalf("incorrect Content-Type %#v", ct)
+	}
+	resp = getTestResponse("GET", "/serialize/struct", "", map[string][]string{"Accept": {"text/xml"}}, nil)
+	if ct := resp.headers["Content-Type"]; len(ct) != 1 || ct[0] != "application/xml; charset=utf-8" {
+		t.Fatalf("incorrect Content-Type %#v", ct)
+	}
+}
+
+type hexColor [3]byte
+
+func (c *hexColor) UnmarshalText(text []byte) error {
+	if _, err := fmt.Sscanf(string(text), "%02x%02x%02x", &c[0], &c[1], &c[2]); err != nil {
+		return errors.New("not a color")
+	}
+	return nil
+}
+
+func TestTypedArguments(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/int/(.*)/(.*)", func(a int, b int64) string { return strconv.FormatInt(int64(a)+b, 10) })
+	s.Get("/uint/(.*)", func(ctx *Context, a uint8) string { return fmt.Sprint(a + 1) })
+	s.Get("/float/(.*)", func(f float64) string { return fmt.Sprint(f * 2) })
+	s.Get("/bool/(.*)", func(b bool) string { return fmt.Sprint(!b) })
+	s.Get("/color/(.*)", func(c hexColor) string { return fmt.Sprint(c[0], c[1], c[2]) })
+	s.Get("/colorptr/(.*)", func(c *hexColor) string { return fmt.Sprint(c[0]) })
+	s.Get("/variadic/(.*)/(.*)", func(n ...int) string { return fmt.Sprint(n) })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/int/1/2", 200, "3"},
+		{"/int/1/x", 400, `Invalid path parameter "x": invalid syntax`},
+		{"/uint/254", 200, "255"},
+		{"/uint/256", 400, `Invalid path parameter "256": value out of range`},
+		{"/float/1.5", 200, "3"},
+		{"/bool/true", 200, "false"},
+		{"/color/ff0001", 200, "255 0 1"},
+		{"/color/red", 400, `Invalid path parameter "red": not a color`},
+		{"/colorptr/0a0000", 200, "10"},
+		{"/variadic/1/2", 200, "[1 2]"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestPathParamsByName(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Provide(&testStore{name: "store"})
+	s.Get("/only/:id", func(ctx *Context) string { return ctx.PathParams["id"] })
+	s.Get("/swap/:b/:a", func(ctx *Context) string { return ctx.PathParams["a"] + ctx.PathParams["b"] })
+	s.Get("/std/{id:[0-9]+}", func(c context.Context) string { return c.(*Context).PathParams["id"] })
+	s.Get("/store/:id", func(ctx *Context, store *testStore) string { return store.name + ctx.PathParams["id"] })
+	s.Get("/positional/:a/:b", func(ctx *Context, a string, b string) string { return a + b })
+
+	tests := [][]string{
+		{"/only/7", "7"},
+		{"/swap/x/y", "yx"},
+		{"/std/42", "42"},
+		{"/store/1", "store1"},
+		{"/positional/x/y", "xy"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test[0], "", nil)
+		if resp.statusCode != 200 || resp.body != test[1] {
+			t.Fatalf("GET %v expected %q got %d %q", test[0], test[1], resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestHandlerValidation(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	valid := []*Route{
+		s.Get("/a", func() {}),
+		s.Get("/b/(.*)", func(ctx *Context, v int) error { return nil }),
+		s.Get("/c/(.*)/(.*)", func(v ...string) ([]byte, error) { return nil, nil }),
+		s.Get("/d/(a)?", func(v string) interface{} { return nil }),
+		s.Handle("/e/(.*)", "GET", &TestHandler{}),
+	}
+	for _, rt := range valid {
+		if rt.Err() != nil {
+			t.Fatalf("route %q should be valid: %v", rt.Pattern(), rt.Err())
+		}
+	}
+
+	invalid := []*Route{
+		s.Get("/(", func() {}),
+		s.Get("/f/(.*)", func() string { return "" }),
+		s.Get("/g", func(v string) string { return v }),
+		s.Get("/h/(.*)", func(v []string) string { return "" }),
+		s.Get("/i", func() int { return 0 }),
+		s.Get("/j", func() (string, string) { return "", "" }),
+		s.Get("/k", "not a function"),
+		s.Get("/l/(.*)", func(a, b string, c ...string) {}),
+		s.Get("/m/(.*)", func(ctx *Context) string { return "" }),
+		s.Get("/n/:id/(.*)", func(ctx *Context) string { return "" }),
+	}
+	for _, rt := range invalid {
+		if rt.Err() == nil {
+			t.Fatalf("route %q should be invalid", rt.Pattern())
+		}
+	}
+	if resp := processTestRequest(s, "GET", "/f/x", "", nil); resp.statusCode != 404 {
+		t.Fatalf("an invalid route should not be served, got %d", resp.statusCode)
+	}
+
+	s.Config = &ServerConfig{StrictRoutes: true}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("an invalid route should panic in strict mode")
+		}
+	}()
+	s.Get("/m/(.*)", func() {})
+}
+
+func TestCompiledInvokers(t *testing.T) {
+	type text string
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/a", func() {})
+	s.Get("/b", func() string { return "b" })
+	s.Get("/c", func() []byte { return []byte("c") })
+	s.Get("/d", func() error { return &HTTPError{Code: 404} })
+	s.Get("/e/(.*)", func(v string) string { return v })
+	s.Get("/f/(.*)/(.*)", func(a, b string) string { return a + b })
+	s.Get("/g", func(ctx *Context) { ctx.WriteString("g") })
+	s.Get("/h", func(ctx *Context) (string, error) { return "", errors.New("h") })
+	s.Get("/i/(.*)", func(ctx *Context, v string) string { return v })
+	s.Get("/j/(.*)/(.*)", func(ctx *Context, a, b string) string { return a + b })
+	s.Get("/k/(.*)", func(v text) text { return v + "k" })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a", 200, ""},
+		{"/b", 200, "b"},
+		{"/c", 200, "c"},
+		{"/d", 404, "Not Found"},
+		{"/e/e", 200, "e"},
+		{"/f/f/f", 200, "ff"},
+		{"/g", 200, "g"},
+		{"/h", 500, "Server Error"},
+		{"/i/i", 200, "i"},
+		{"/j/j/j", 200, "jj"},
+		{"/k/k", 200, "kk"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+}
+
+type testStore struct{ name string }
+
+type testConfig struct{ Limit int }
+
+func (st *testStore) String() string { return st.name }
+
+func TestServiceInjection(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Env = map[string]interface{}{}
+	s.Provide(&testStore{name: "store"})
+	s.Env["config"] = testConfig{Limit: 10}
+	s.Provide(nil)
+
+	s.Get("/a/(.*)", func(ctx *Context, id string, st *testStore, config testConfig) string {
+		return fmt.Sprint(id, " ", st.name, " ", config.Limit)
+	})
+	s.Get("/b", func(st fmt.Stringer) string { return st.String() })
+	for _, test := range [][]string{{"/a/1", "1 store 10"}, {"/b", "store"}} {
+		resp := processTestRequest(s, "GET", test[0], "", nil)
+		if resp.body != test[1] {
+			t.Fatalf("GET %v expected %q got %q", test[0], test[1], resp.body)
+		}
+	}
+
+	if rt := s.Get("/c", func(f *bytes.Buffer) string { return "" }); rt.Err() == nil {
+		t.Fatalf("a parameter without a matching service should be rejected")
+	}
+	// values that could be path parameters are never injected
+	s.Env["limit"] = 10
+	if rt := s.Get("/e", func(n int) string { return "" }); rt.Err() == nil {
+		t.Fatalf("a parameter without a capture group should be rejected")
+	}
+	s.Env["other"] = &testStore{name: "other"}
+	if rt := s.Get("/d", func(st *testStore) string { return "" }); rt.Err() == nil {
+		t.Fatalf("a parameter matching several services should be rejected")
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/std/(.*)", func(c context.Context, v string) string {
+		if _, ok := c.(*Context); !ok {
+			return "not a web.Context"
+		}
+		return v
+	})
+	s.Get("/slow", func(ctx *Context) string {
+		if _, ok := ctx.Deadline(); !ok {
+			return "no deadline"
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err().Error()
+		case <-time.After(5 * time.Second):
+			return "not canceled"
+		}
+	}).Timeout(10 * time.Millisecond)
+	s.Handle("/raw", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		io.WriteString(w, "raw canceled")
+	})).Timeout(10 * time.Millisecond)
+
+	tests := [][]string{
+		{"/std/a", "a"},
+		{"/slow", context.DeadlineExceeded.Error()},
+		{"/raw", "raw canceled"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test[0], "", nil)
+		if resp.body != test[1] {
+			t.Fatalf("GET %v expected %q got %q", test[0], test[1], resp.body)
+		}
+	}
+}
+
+func TestCloseCancelsRequests(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	started := make(chan bool)
+	s.Get("/wait", func(ctx *Context) string {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return ctx.Err().Error()
+		case <-time.After(5 * time.Second):
+			return "not canceled"
+		}
+	})
+
+	done := make(chan *testResponse)
+	go func() { done <- processTestRequest(s, "GET", "/wait", "", nil) }()
+	<-started
+	s.Close()
+	if resp := <-done; resp.body != context.Canceled.Error() {
+		t.Fatalf("expected the request to be canceled by Close, got %q", resp.body)
+	}
+}
+
+func TestContextStore(t *testing.T) {
+	type user struct{ name string }
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Use(func(ctx *Context, next func()) {
+		ctx.Set("user", &user{name: "alice"})
+		next()
+	})
+	s.Get("/handler", func(ctx *Context) string {
+		u, ok := GetAs[*user](ctx, "user")
+		if !ok {
+			return "missing"
+		}
+		if _, ok := GetAs[string](ctx, "user"); ok {
+			return "wrong type"
+		}
+		if _, ok := ctx.Get("other"); ok {
+			return "unexpected value"
+		}
+		return u.name + " " + ctx.MustGet("user").(*user).name + " " + ctx.Value("user").(*user).name
+	})
+	s.Handle("/raw", "GET", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		u, _ := RequestValue(req, "user")
+		io.WriteString(w, u.(*user).name)
+	}))
+	s.Get("/mustget", func(ctx *Context) string { return ctx.MustGet("other").(string) })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/handler", 200, "alice alice alice"},
+		{"/raw", 200, "alice"},
+		{"/mustget", 500, "Server Error"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestMultipartParams(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Post("/fields", func(ctx *Context) string {
+		n, err := ctx.ParamInt("n", 0)
+		return fmt.Sprint(ctx.Params["title"], " ", ctx.BodyValues("title"), " ", ctx.ParamValues("q"), " ", n, " ", err)
+	})
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("title", "uploads")
+	w.WriteField("n", "3")
+	w.Close()
+	headers := map[string][]string{"Content-Type": {w.FormDataContentType()}}
+	resp := processTestRequest(s, "POST", "/fields?q=x", buf.String(), headers)
+	if expect := "uploads [uploads] [x] 3 <nil>"; resp.statusCode != 200 || resp.body != expect {
+		t.Fatalf("expected multipart fields %q got %d %q", expect, resp.statusCode, resp.body)
+	}
+}
+
+func TestMultipartReader(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Post("/stream", func(ctx *Context) (string, error) {
+		r, err := ctx.Request.MultipartReader()
+		if err != nil {
+			return "", err
+		}
+		part, err := r.NextPart()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(part)
+		return part.FormName() + "=" + string(data), err
+	})
+
+	body, headers := multipartBody()
+	resp := processTestRequest(s, "POST", "/stream", body, headers)
+	if expect := "title=uploads"; resp.statusCode != 200 || resp.body != expect {
+		t.Fatalf("expected the multipart body to be left unread, got %d %q", resp.statusCode, resp.body)
+	}
+}
+
+func TestTypedParams(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Post("/values", func(ctx *Context) string {
+		return fmt.Sprint(ctx.ParamValues("a"), ctx.QueryValues("a"), ctx.BodyValues("a"))
+	})
+	s.Get("/typed", func(ctx *Context) (string, error) {
+		n, err := ctx.ParamInt("n", -1)
+		if err != nil {
+			return "", err
+		}
+		f, err := ctx.ParamFloat("f", 0.5)
+		if err != nil {
+			return "", err
+		}
+		b, err := ctx.ParamBool("b", false)
+		if err != nil {
+			return "", err
+		}
+		tm, err := ctx.ParamTime("t", "2006-01-02")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(n, " ", f, " ", b, " ", tm.Format("Jan 2")), nil
+	})
+
+	form := map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}}
+	resp := processTestRequest(s, "POST", "/values?a=1&a=2", "a=3&a=4", form)
+	if resp.body != "[3 4 1 2] [1 2] [3 4]" {
+		t.Fatalf("incorrect parameter values %q", resp.body)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/typed", 200, "-1 0.5 false Jan 1"},
+		{"/typed?n=3&f=1.25&b&t=2016-08-09", 200, "3 1.25 true Aug 9"},
+		{"/typed?b=0", 200, "-1 0.5 false Jan 1"},
+		{"/typed?n=x", 400, `Invalid value "x" for parameter "n": invalid syntax`},
+		{"/typed?b=maybe", 400, `Invalid value "maybe" for parameter "b": invalid syntax`},
+		{"/typed?t=yesterday", 400, `Invalid value "yesterday" for parameter "t": parsing time "yesterday" as "2006-01-02": cannot parse "yesterday" as "2006"`},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.body {
+			t.Fatalf("GET %v expected %d %q got %d %q", test.path, test.status, test.body, resp.statusCode, resp.body)
+		}
+	}
+}
+
+type testSignup struct {
+	Name     string   `json:"name" xml:"name" form:"name" validate:"required,min=2,max=10"`
+	Email    string   `json:"email" xml:"email" form:"email" validate:"required,email"`
+	Age      int      `json:"age" xml:"age" form:"age" validate:"min=18"`
+	Plan     string   `json:"plan" xml:"plan" form:"plan" validate:"oneof=free pro"`
+	Tags     []string `json:"tags" xml:"tag" form:"tag" validate:"max=2"`
+	Code     *string  `json:"code" xml:"code" form:"code" validate:"regexp=^[A-Z]{2,3}$"`
+	Internal string   `json:"-" xml:"-" form:"-"`
+}
+
+func TestBind(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	bind := func(ctx *Context) (string, error) {
+		var v testSignup
+		if err := ctx.Bind(&v); err != nil {
+			return "", err
+		}
+		code := ""
+		if v.Code != nil {
+			code = *v.Code
+		}
+		return fmt.Sprint(v.Name, " ", v.Email, " ", v.Age, " ", v.Plan, " ", v.Tags, " ", code, " ", v.Internal), nil
+	}
+	s.Get("/bind", bind)
+	s.Post("/bind", bind)
+
+	jsonType := map[string][]string{"Content-Type": {"application/json"}}
+	formType := map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}}
+	tests := []struct {
+		method  string
+		path    string
+		headers map[string][]string
+		body    string
+		status  int
+		expect  string
+	}{
+		{"POST", "/bind", jsonType, `{"name":"bob","email":"bob@example.com","age":20,"tags":["a"],"code":"AB"}`, 200, "bob bob@example.com 20  [a] AB "},
+		{"POST", "/bind", map[string][]string{"Content-Type": {"text/xml; charset=utf-8"}}, `<signup><name>bob</name><email>bob@example.com</email><tag>a</tag><tag>b</tag></signup>`, 200, "bob bob@example.com 0  [a b]  "},
+		{"POST", "/bind", formType, "name=bob&email=bob@example.com&age=30&plan=pro&tag=x&code=ABC&Internal=x", 200, "bob bob@example.com 30 pro [x] ABC "},
+		{"GET", "/bind?name=bob&email=bob@example.com", nil, "", 200, "bob bob@example.com 0  []  "},
+		{"POST", "/bind", formType, "name=bob&email=bob@example.com&age=old", 400, `Invalid value "old" for parameter "age": invalid syntax`},
+		{"POST", "/bind", jsonType, `{"name":`, 400, "Malformed request body: unexpected EOF"},
+		{"POST", "/bind", map[string][]string{"Content-Type": {"text/csv"}}, "a,b", 415, "Unsupported Content-Type text/csv"},
+		{"POST", "/bind", jsonType, `{"name":"b","email":"bob","age":3,"plan":"gold","tags":["a","b","c"],"code":"abc"}`, 422,
+			"name must have a length of at least 2; email must be a valid email address; age must be at least 18; plan must be one of free, pro; tags must have a length of at most 2; code must match ^[A-Z]{2,3}$"},
+		{"POST", "/bind", jsonType, `{}`, 422, "name is required; email is required"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, test.method, test.path, test.body, test.headers)
+		if resp.statusCode != test.status || resp.body != test.expect {
+			t.Fatalf("%v %v %q expected %d %q got %d %q", test.method, test.path, test.body, test.status, test.expect, resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"required"`
+	}
+	v := struct {
+		Home   address
+		Others []address `json:"others"`
+	}{Others: []address{{City: "x"}, {}}}
+	err := Validate(&v)
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected two validation errors, got %v", err)
+	}
+	if errs[0].Field != "Home.city" || errs[1].Field != "others[1].city" || errs[1].Rule != "required" {
+		t.Fatalf("incorrect validation errors %#v", errs)
+	}
+	if ErrorStatus(err) != 422 {
+		t.Fatalf("validation errors should have status 422")
+	}
+}
+
+// countingReader records whether the request body was read.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReader) Close() error { return nil }
+
+func TestBodyLimits(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{MaxBodyBytes: 10}
+	echo := func(ctx *Context) (string, error) { return ctx.Params["a"], ctx.ParseForm() }
+	s.Post("/limited", echo)
+	s.Post("/params", func(ctx *Context) string { return "params " + ctx.Params["a"] })
+	s.Post("/int", func(ctx *Context) (string, error) {
+		n, err := ctx.ParamInt("a", 0)
+		return fmt.Sprint(n), err
+	})
+	s.Post("/unlimited", echo).MaxBodyBytes(-1)
+	s.Post("/larger", echo).MaxBodyBytes(100)
+	s.Post("/bind", func(ctx *Context) (string, error) {
+		var v map[string]string
+		if err := ctx.Bind(&v); err != nil {
+			return "", err
+		}
+		return v["a"], nil
+	})
+	s.Post("/plain", func() string { return "plain" })
+
+	form := map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}}
+	long := "a=" + strings.Repeat("x", 20)
+	tests := []struct {
+		path    string
+		headers map[string][]string
+		body    string
+		status  int
+		expect  string
+	}{
+		{"/limited", form, "a=short", 200, "short"},
+		{"/limited", form, long, 413, "Request body too large"},
+		{"/unlimited", form, long, 200, long[2:]},
+		{"/larger", form, long, 200, long[2:]},
+		{"/limited?a=%zz", form, "", 400, `Malformed query string: invalid URL escape "%zz"`},
+		{"/limited", form, "a=%zz", 400, `Malformed request body: invalid URL escape "%zz"`},
+		{"/params", form, long, 200, "params "},
+		{"/params?a=%zz", form, "", 200, "params "},
+		{"/int", form, long, 413, "Request body too large"},
+		{"/int?a=%zz", form, "", 400, `Malformed query string: invalid URL escape "%zz"`},
+		{"/bind", map[string][]string{"Content-Type": {"application/json"}}, `{"a":"` + long + `"}`, 413, "Request body too large"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "POST", test.path, test.body, test.headers)
+		if resp.statusCode != test.status || resp.body != test.expect {
+			t.Fatalf("POST %v %q expected %d %q got %d %q", test.path, test.body, test.status, test.expect, resp.statusCode, resp.body)
+		}
+	}
+
+	// handlers without a Context don't have the body read for them
+	req := buildTestRequest("POST", "/plain", long, form, nil)
+	body := &countingReader{Reader: strings.NewReader(long)}
+	req.Body = body
+	var buf bytes.Buffer
+	c := scgiConn{wroteHeaders: false, req: req, headers: make(map[string][]string), fd: &ioBuffer{output: &buf}}
+	s.Process(&c, req)
+	if resp := buildTestResponse(&buf); resp.statusCode != 200 || resp.body != "plain" || body.n != 0 {
+		t.Fatalf("expected an unread body, got %d %q after reading %d bytes", resp.statusCode, resp.body, body.n)
+	}
+}
+
+// multipartBody encodes files, given as name and content pairs, as a
+// multipart form with the field name "file".
+func multipartBody(files ...string) (string, map[string][]string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("title", "uploads")
+	for i := 0; i < len(files); i += 2 {
+		part, _ := w.CreateFormFile("file", files[i])
+		part.Write([]byte(files[i+1]))
+	}
+	w.Close()
+	return buf.String(), map[string][]string{"Content-Type": {w.FormDataContentType()}}
+}
+
+func TestUploads(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{
+		MultipartMemory:  1,
+		MaxFileBytes:     100,
+		AllowedFileTypes: []string{"image/*", "text/plain"},
+	}
+	dir := t.TempDir()
+	var saved *multipart.FileHeader
+	s.Post("/upload", func(ctx *Context) (string, error) {
+		fh, err := ctx.FormFile("file")
+		if err != nil {
+			return "", err
+		}
+		saved = fh
+		if err := ctx.SaveUploadedFile(fh, filepath.Join(dir, fh.Filename)); err != nil {
+			return "", err
+		}
+		return fmt.Sprint(ctx.Request.FormValue("title"), " ", fh.Filename, " ", fh.Size), nil
+	})
+	s.Post("/uploads", func(ctx *Context) (string, error) {
+		files, err := ctx.FormFiles("file")
+		if err != nil {
+			return "", err
+		}
+		var names []string
+		for _, fh := range files {
+			names = append(names, fh.Filename)
+		}
+		return strings.Join(names, ","), nil
+	})
+
+	png := "\x89PNG\r\n\x1a\n0000"
+	tests := []struct {
+		path   string
+		files  []string
+		status int
+		expect string
+	}{
+		{"/upload", []string{"a.txt", "hello"}, 200, "uploads a.txt 5"},
+		{"/upload", []string{"a.png", png}, 200, "uploads a.png 12"},
+		{"/upload", []string{"a.pdf", "%PDF-1.4 0000"}, 415, `File "a.pdf" has unsupported type application/pdf`},
+		{"/upload", []string{"b.txt", strings.Repeat("x", 101)}, 413, `File "b.txt" is too large`},
+		{"/upload", nil, 400, `Missing file "file"`},
+		{"/uploads", []string{"a.txt", "a", "b.png", png}, 200, "a.txt,b.png"},
+		{"/uploads", nil, 200, ""},
+	}
+	for _, test := range tests {
+		body, headers := multipartBody(test.files...)
+		resp := processTestRequest(s, "POST", test.path, body, headers)
+		if resp.statusCode != test.status || resp.body != test.expect {
+			t.Fatalf("POST %v %q expected %d %q got %d %q", test.path, test.files, test.status, test.expect, resp.statusCode, resp.body)
+		}
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(content) != "hello" {
+		t.Fatalf("expected the upload to be saved, got %q %v", content, err)
+	}
+	// the temporary files are removed once the handler returns
+	if f, err := saved.Open(); err == nil {
+		f.Close()
+		t.Fatalf("expected temporary upload files to be removed")
+	}
+
+	resp := processTestRequest(s, "POST", "/upload", "a=b", map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.statusCode != 400 {
+		t.Fatalf("expected a 400 for a request that isn't multipart, got %d %q", resp.statusCode, resp.body)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		offers []string
+		expect string
+	}{
+		{"Accept", "", []string{"text/html", "application/json"}, "text/html"},
+		{"Accept", "application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"Accept", "text/*;q=0.5, application/json;q=0.4", []string{"application/json", "text/plain"}, "text/plain"},
+		{"Accept", "*/*, application/xml", []string{"application/json", "application/xml"}, "application/xml"},
+		{"Accept", "text/html;level=1, */*;q=0.1, image/png;q=0", []string{"image/png", "text/csv"}, "text/csv"},
+		{"Accept", "TEXT/HTML", []string{"text/html; charset=utf-8"}, "text/html; charset=utf-8"},
+		{"Accept", "text/html, application/xml;q=bad", []string{"application/xml"}, ""},
+		{"Accept-Language", "da, en-gb;q=0.8, en;q=0.7", []string{"en-US", "en-GB", "fr"}, "en-GB"},
+		{"Accept-Language", "fr-CH, fr;q=0.9", []string{"de", "fr"}, "fr"},
+		{"Accept-Language", "de", []string{"en"}, ""},
+		{"Accept-Encoding", "gzip;q=0.5, br", []string{"gzip", "br"}, "br"},
+		{"Accept-Encoding", "gzip", []string{"br", "identity"}, "identity"},
+		{"Accept-Encoding", "gzip, identity;q=0", []string{"br", "identity"}, ""},
+		{"Accept-Encoding", "*;q=0", []string{"identity"}, ""},
+	}
+	for _, test := range tests {
+		req := buildTestRequest("GET", "/", "", map[string][]string{test.header: {test.value}}, nil)
+		if test.value == "" {
+			req.Header.Del(test.header)
+		}
+		ctx := &Context{Request: req}
+		var got string
+		switch test.header {
+		case "Accept":
+			got = ctx.Accepts(test.offers...)
+		case "Accept-Language":
+			got = ctx.AcceptsLanguage(test.offers...)
+		case "Accept-Encoding":
+			got = ctx.AcceptsEncoding(test.offers...)
+		}
+		if got != test.expect {
+			t.Errorf("%s: %q with offers %q expected %q got %q", test.header, test.value, test.offers, test.expect, got)
+		}
+	}
+
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/doc", func(ctx *Context) (string, error) {
+		return ctx.Negotiate("text/html", "application/json")
+	})
+	resp := processTestRequest(s, "GET", "/doc", "", map[string][]string{"Accept": {"image/png"}})
+	if resp.statusCode != 406 || resp.body != "Not acceptable, available types are text/html, application/json" {
+		t.Fatalf("expected a 406 response, got %d %q", resp.statusCode, resp.body)
+	}
+}
+
+func TestRenderHelpers(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/json", func(ctx *Context) error {
+		return ctx.JSON(201, map[string]int{"a": 1})
+	})
+	s.Get("/xml", func(ctx *Context) error {
+		return ctx.XML(202, struct {
+			XMLName xml.Name `xml:"point"`
+			X       int      `xml:"x"`
+		}{X: 1})
+	})
+	s.Get("/text", func(ctx *Context) error { return ctx.Text(404, "missing") })
+	s.Get("/blob", func(ctx *Context) error { return ctx.Blob(200, "image/png", []byte{1, 2, 3}) })
+	s.Get("/stream", func(ctx *Context) error {
+		return ctx.Stream(200, "text/csv", strings.NewReader("a,b\n"))
+	})
+	s.Get("/unencodable", func(ctx *Context) error { return ctx.JSON(200, func() {}) })
+
+	tests := []struct {
+		path   string
+		status int
+		ctype  string
+		body   string
+	}{
+		{"/json", 201, "application/json; charset=utf-8", `{"a":1}`},
+		{"/xml", 202, "application/xml; charset=utf-8", `<point><x>1</x></point>`},
+		{"/text", 404, "text/plain; charset=utf-8", "missing"},
+		{"/blob", 200, "image/png", "\x01\x02\x03"},
+		{"/stream", 200, "text/csv", "a,b\n"},
+	}
+	for _, test := range tests {
+		for _, method := range []string{"GET", "HEAD"} {
+			resp := processTestRequest(s, method, test.path, "", nil)
+			body := test.body
+			if method == "HEAD" {
+				body = ""
+			}
+			if resp.statusCode != test.status || resp.body != body {
+				t.Fatalf("%s %s expected %d %q got %d %q", method, test.path, test.status, body, resp.statusCode, resp.body)
+			}
+			if ctype := resp.headers["Content-Type"]; len(ctype) != 1 || ctype[0] != test.ctype {
+				t.Fatalf("%s %s expected content type %q got %q", method, test.path, test.ctype, ctype)
+			}
+			if cl := resp.headers["Content-Length"]; len(cl) != 1 || cl[0] != strconv.Itoa(len(test.body)) {
+				t.Fatalf("%s %s expected content length %d got %q", method, test.path, len(test.body), cl)
+			}
+		}
+	}
+
+	resp := processTestRequest(s, "GET", "/unencodable", "", nil)
+	if resp.statusCode != 500 || resp.body != "Server Error" {
+		t.Fatalf("expected a server error, got %d %q", resp.statusCode, resp.body)
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/users/:id", func(id string) string { return id }).Name("user")
+	s.Templates = &TemplateConfig{
+		FS: fstest.MapFS{
+			"layouts/base.html":  {Data: []byte(`<title>{{block "title" .}}site{{end}}</title>{{template "content" .}}`)},
+			"partials/user.html": {Data: []byte(`<a href="{{URLFor "user" .ID}}">{{shout .Name}}</a>`)},
+			"users/show.html":    {Data: []byte(`{{define "title"}}{{.Name}}{{end}}{{define "content"}}{{template "partials/user" .}}{{end}}`)},
+			"index.html":         {Data: []byte(`{{define "content"}}<b>{{.}}</b>{{end}}`)},
+			"notes.txt":          {Data: []byte(`not a template`)},
+		},
+		Layout: "layouts/base",
+		Funcs:  template.FuncMap{"shout": strings.ToUpper},
+	}
+	s.Get("/render/(.*)", func(ctx *Context, name string) error {
+		return ctx.Render(201, name, map[string]interface{}{"ID": 7, "Name": "<bob>"})
+	})
+	s.Get("/", func(ctx *Context) error { return ctx.Render(200, "index", "a & b") })
+
+	tests := []struct {
+		path   string
+		status int
+		expect string
+	}{
+		{"/render/users/show", 201, `<title>&lt;bob&gt;</title><a href="/users/7">&lt;BOB&gt;</a>`},
+		{"/", 200, `<title>site</title><b>a &amp; b</b>`},
+		{"/render/notes", 500, "Server Error"},
+		{"/render/partials/user", 500, "Server Error"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if resp.statusCode != test.status || resp.body != test.expect {
+			t.Fatalf("GET %s expected %d %q got %d %q", test.path, test.status, test.expect, resp.statusCode, resp.body)
+		}
+	}
+}
+
+func TestRenderReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "page.tmpl")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(file, modTime, modTime)
+	}
+	write("one", time.Now().Add(-time.Hour))
+
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Templates = &TemplateConfig{Dir: dir, Ext: ".tmpl"}
+	s.Get("/", func(ctx *Context) error { return ctx.Render(200, "page", nil) })
+
+	if resp := processTestRequest(s, "GET", "/", "", nil); resp.body != "one" {
+		t.Fatalf("expected the template to render, got %q", resp.body)
+	}
+	write("two", time.Now())
+	if resp := processTestRequest(s, "GET", "/", "", nil); resp.body != "one" {
+		t.Fatalf("expected templates to be cached without Reload, got %q", resp.body)
+	}
+	s.Templates.Reload = true
+	if resp := processTestRequest(s, "GET", "/", "", nil); resp.body != "two" {
+		t.Fatalf("expected the changed template to be reloaded, got %q", resp.body)
+	}
+}
+
+func TestStaticFiles(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{StaticFS: fstest.MapFS{
+		"robots.txt":      {Data: []byte("User-agent: *"), ModTime: modTime},
+		"docs/index.html": {Data: []byte("<h1>docs</h1>"), ModTime: modTime},
+		"assets/site.css": {Data: []byte("shadowed")},
+	}}
+	s.Static("/assets/", fstest.MapFS{
+		"site.css": {Data: []byte("body{}"), ModTime: modTime},
+	})
+	s.Static("/vendor", fstest.MapFS{
+		"lib/app.js": {Data: []byte("0123456789")},
+	})
+	s.Get("/assets/(.*)", func(name string) string { return "route " + name })
+
+	tests := []struct {
+		path    string
+		headers map[string][]string
+		status  int
+		expect  string
+	}{
+		{"/robots.txt", nil, 200, "User-agent: *"},
+		{"/docs/", nil, 200, "<h1>docs</h1>"},
+		{"/assets/site.css", nil, 200, "body{}"},
+		{"/assets/../robots.txt", nil, 200, "User-agent: *"},
+		{"/assets/missing.css", nil, 200, "route missing.css"},
+		{"/vendor/lib/app.js", map[string][]string{"Range": {"bytes=2-4"}}, 206, "234"},
+		{"/vendor/lib/", nil, 404, "Page not found"},
+		{"/vendorlib/app.js", nil, 404, "Page not found"},
+		{"/robots.txt", map[string][]string{"If-Modified-Since": {modTime.Format(http.TimeFormat)}}, 304, ""},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", test.headers)
+		if resp.statusCode != test.status || resp.body != test.expect {
+			t.Fatalf("GET %s expected %d %q got %d %q", test.path, test.status, test.expect, resp.statusCode, resp.body)
+		}
+	}
+
+	resp := processTestRequest(s, "GET", "/assets/site.css", "", nil)
+	if ctype := resp.headers["Content-Type"]; len(ctype) != 1 || ctype[0] != "text/css; charset=utf-8" {
+		t.Fatalf("expected a css content type, got %q", ctype)
+	}
+	if lm := resp.headers["Last-Modified"]; len(lm) != 1 || lm[0] != modTime.Format(http.TimeFormat) {
+		t.Fatalf("expected a Last-Modified header, got %q", lm)
+	}
+}
+
+func TestPrecompressedStatic(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{StaticFS: fstest.MapFS{
+		"app.js":       {Data: []byte("plain")},
+		"app.js.gz":    {Data: []byte("gzipped")},
+		"app.js.br":    {Data: []byte("brotli")},
+		"site.css":     {Data: []byte("plain css")},
+		"site.css.gz":  {Data: []byte("gzipped css")},
+		"logo.png.gz":  {Data: []byte("no original")},
+		"robots.txt":   {Data: []byte("robots")},
+		"data.json.gz": {Data: []byte("gz only")},
+		"data.json":    {Data: []byte("{}")},
+	}}
+
+	tests := []struct {
+		path     string
+		accept   string
+		body     string
+		encoding string
+		vary     bool
+	}{
+		{"/app.js", "", "plain", "", true},
+		{"/app.js", "gzip", "gzipped", "gzip", true},
+		{"/app.js", "gzip, deflate, br", "brotli", "br", true},
+		{"/app.js", "br;q=0.5, gzip", "gzipped", "gzip", true},
+		{"/app.js", "*", "brotli", "br", true},
+		{"/app.js", "deflate", "plain", "", true},
+		{"/site.css", "br", "plain css", "", true},
+		{"/site.css", "gzip", "gzipped css", "gzip", true},
+		{"/data.json", "gzip;q=0, identity", "{}", "", true},
+		{"/robots.txt", "gzip", "robots", "", false},
+	}
+	for _, test := range tests {
+		headers := map[string][]string{}
+		if test.accept != "" {
+			headers["Accept-Encoding"] = []string{test.accept}
+		}
+		resp := processTestRequest(s, "GET", test.path, "", headers)
+		if resp.statusCode != 200 || resp.body != test.body {
+			t.Fatalf("GET %s with %q expected %q got %d %q", test.path, test.accept, test.body, resp.statusCode, resp.body)
+		}
+		if enc := strings.Join(resp.headers["Content-Encoding"], ","); enc != test.encoding {
+			t.Fatalf("GET %s with %q expected encoding %q got %q", test.path, test.accept, test.encoding, enc)
+		}
+		if vary := strings.Join(resp.headers["Vary"], ","); (vary == "Accept-Encoding") != test.vary {
+			t.Fatalf("GET %s with %q got Vary %q", test.path, test.accept, vary)
+		}
+	}
+
+	resp := processTestRequest(s, "GET", "/app.js", "", map[string][]string{"Accept-Encoding": {"gzip"}})
+	if ctype := resp.headers["Content-Type"]; len(ctype) != 1 || !strings.Contains(ctype[0], "javascript") {
+		t.Fatalf("expected the content type of the uncompressed file, got %q", ctype)
+	}
+	if resp := processTestRequest(s, "GET", "/logo.png", "", map[string][]string{"Accept-Encoding": {"gzip"}}); resp.statusCode != 404 {
+		t.Fatalf("expected compressed files without an original to be ignored, got %d", resp.statusCode)
+	}
+}
+
+func TestStaticCacheControl(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Config = &ServerConfig{
+		StaticFS: fstest.MapFS{
+			"app.3f9a2c1e.js":      {Data: []byte("js")},
+			"app-5XGW2UTC.css":     {Data: []byte("css")},
+			"app.js":               {Data: []byte("js")},
+			"docs/guide.html":      {Data: []byte("guide")},
+			"docs/release-notes.4": {Data: []byte("notes")},
+			"fonts/serif.woff2":    {Data: []byte("font")},
+		},
+		StaticCache: []CachePolicy{
+			{Fingerprinted: true, CacheControl: ImmutableCacheControl},
+			{Prefix: "/docs/", CacheControl: "no-cache"},
+			{Extensions: []string{".js", ".WOFF2"}, CacheControl: "public, max-age=3600"},
+		},
+	}
+
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"/app.3f9a2c1e.js", ImmutableCacheControl},
+		{"/app-5XGW2UTC.css", ImmutableCacheControl},
+		{"/app.js", "public, max-age=3600"},
+		{"/docs/guide.html", "no-cache"},
+		{"/docs/release-notes.4", "no-cache"},
+		{"/fonts/serif.woff2", "public, max-age=3600"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test.path, "", nil)
+		if cc := strings.Join(resp.headers["Cache-Control"], ","); resp.statusCode != 200 || cc != test.expect {
+			t.Fatalf("GET %s expected Cache-Control %q got %d %q", test.path, test.expect, resp.statusCode, cc)
+		}
+	}
+
+	for name, expect := range map[string]bool{
+		"app.3f9a2c1e.js":    true,
+		"chunk-A1B2C3D4.js":  true,
+		"main.0123456789.js": true,
+		"README.md":          false,
+		"changelog.js":       false,
+		"CHANGELOG-2.md":     false,
+		"deadbeefcafe.js":    false,
+		"app.3f9a2c1E.js":    false,
+		"a1b2c3d4e5":         false,
+	} {
+		if fingerprinted(name) != expect {
+			t.Errorf("fingerprinted(%q) should be %v", name, expect)
+		}
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/", func() string { return "" }).Name("index")
+	s.Get("/users/:id/posts/{slug:[a-z-]+}", func(id, slug string) string { return "" }).Name("post")
+	s.Get("/files/(.*)", func(name string) string { return "" }).Name("file")
+	s.Get("/v1\\.0/(a|b)", func(v string) string { return "" }).Name("version")
+	s.Get("/(a|b)/x", func(v string) string { return "" }).Name("alternation")
+	s.Get("/a+", func() string { return "" }).Name("repetition")
+
+	tests := []struct {
+		name string
+		args []interface{}
+		url  string
+	}{
+		{"index", nil, "/"},
+		{"post", []interface{}{12, "hello-world"}, "/users/12/posts/hello-world"},
+		{"post", []interface{}{"a b", "c"}, "/users/a%20b/posts/c"},
+		{"file", []interface{}{"css/app 1.css"}, "/files/css/app%201.css"},
+		{"version", []interface{}{"b"}, "/v1.0/b"},
+		{"alternation", []interface{}{"a"}, "/a/x"},
+	}
+	for _, test := range tests {
+		url, err := s.URLFor(test.name, test.args...)
+		if err != nil {
+			t.Fatalf("URLFor(%q, %v) failed: %v", test.name, test.args, err)
+		}
+		if url != test.url {
+			t.Fatalf("URLFor(%q, %v) expected %q got %q", test.name, test.args, test.url, url)
+		}
+	}
+
+	failures := []struct {
+		name string
+		args []interface{}
+	}{
+		{"missing", nil},
+		{"post", []interface{}{12}},
+		{"post", []interface{}{12, "x", "y"}},
+		{"post", []interface{}{12, "Upper"}},
+		{"post", []interface{}{"a/b", "c"}},
+		{"version", []interface{}{"c"}},
+		{"repetition", nil},
+	}
+	for _, test := range failures {
+		if url, err := s.URLFor(test.name, test.args...); err == nil {
+			t.Fatalf("URLFor(%q, %v) should have failed, got %q", test.name, test.args, url)
+		}
+	}
+}
+
 func TestSlug(t *testing.T) {
 	tests := [][]string{
 		{"", ""},
@@ -547,6 +1982,92 @@ func TestSlug(t *testing.T) {
 	}
 }
 
+func TestExpandRoute(t *testing.T) {
+	tests := [][]string{
+		{"/", "/"},
+		{"/echo/(.*)", "/echo/(.*)"},
+		{"/a{2,3}", "/a{2,3}"},
+		{"/(?:a|b)", "/(?:a|b)"},
+		{"/users/:id", "/users/(?P<id>[^/]+)"},
+		{"/users/:id/posts/:slug", "/users/(?P<id>[^/]+)/posts/(?P<slug>[^/]+)"},
+		{"/users/{id}", "/users/(?P<id>[^/]+)"},
+		{"/users/{id:[0-9]+}", "/users/(?P<id>[0-9]+)"},
+		{"/years/{y:[0-9]{4}}/(.*)", "/years/(?P<y>[0-9]{4})/(.*)"},
+	}
+
+	for _, test := range tests {
+		v := expandRoute(test[0])
+		if v != test[1] {
+			t.Fatalf("expandRoute(%v) failed, expected %v, got %v", test[0], test[1], v)
+		}
+	}
+}
+
+// routes served from the tree and through the regex fallback must keep
+// the precedence given by their registration order
+func TestRoutePrecedence(t *testing.T) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/a/(.*)", func(v string) string { return "regex " + v })
+	s.Get("/a/b", func() string { return "static" })
+	s.Get("/b/:name", func(name string) string { return "param " + name })
+	s.Get("/b/c", func() string { return "static" })
+	s.Get("/c/c", func() string { return "static" })
+	s.Get("/c/:name", func(name string) string { return "param " + name })
+	s.Get("/d/robots\\.txt", func() string { return "escaped" })
+	s.Post("/e/:name", func(name string) string { return "post " + name })
+	s.Get("/e/(.*)", func(v string) string { return "regex " + v })
+	s.Get("/users/:id-x", func(id string) string { return "user " + id })
+	s.Get("/files/{name}-raw", func(name string) string { return "file " + name })
+	s.Get("/lit/{id:abc}", func(id string) string { return "lit " + id })
+	s.Get("/f/(.*)", func(v string) string { return "regex " + v })
+	s.Get("/f/g/(\\d+)", func(v string) string { return "digits " + v })
+	s.Get("/h/i/(\\d+)", func(v string) string { return "digits " + v })
+	s.Get("/h/(.*)", func(v string) string { return "regex " + v })
+	s.Get("(?i)/ci/(\\d+)", func(v string) string { return "ci " + v })
+	s.Get("/g/([^/]+)", func(v string) string { return "unnamed " + v })
+
+	tests := [][]string{
+		{"/a/b", "regex b"},
+		{"/b/c", "param c"},
+		{"/c/c", "static"},
+		{"/c/d", "param d"},
+		{"/d/robots.txt", "escaped"},
+		{"/e/f", "regex f"},
+		{"/users/5-x", "user 5"},
+		{"/users/:id-x", "user :id"},
+		{"/files/a-raw", "file a"},
+		{"/lit/abc", "lit abc"},
+		{"/f/g/1", "regex g/1"},
+		{"/h/i/1", "digits 1"},
+		{"/h/i/x", "regex i/x"},
+		{"/CI/1", "ci 1"},
+		{"/g/x", "unnamed x"},
+	}
+	for _, test := range tests {
+		resp := processTestRequest(s, "GET", test[0], "", nil)
+		if resp.body != test[1] {
+			t.Fatalf("GET %v expected %q got %q", test[0], test[1], resp.body)
+		}
+	}
+	if resp := processTestRequest(s, "GET", "/c/", "", nil); resp.statusCode != 404 {
+		t.Fatalf("an empty segment should not match a parameter, got %d", resp.statusCode)
+	}
+	for _, path := range []string{"/lit/{id:abc}", "/lit/x"} {
+		if resp := processTestRequest(s, "GET", path, "", nil); resp.statusCode != 404 {
+			t.Fatalf("GET %v expected 404 got %d %q", path, resp.statusCode, resp.body)
+		}
+	}
+	for _, r := range []string{"/users/:id-x", "/files/{name}-raw", "/lit/{id:abc}"} {
+		if _, _, ok := treeSegments(r); ok {
+			t.Fatalf("route %q mixes a parameter with text and should not be in the tree", r)
+		}
+	}
+	if _, params, ok := treeSegments("/g/([^/]+)"); !ok || !params[1] {
+		t.Fatalf("an unnamed whole-segment group should be a tree parameter")
+	}
+}
+
 // tests that we don't duplicate headers
 func TestDuplicateHeader(t *testing.T) {
 	resp := testGet("/dupeheader", nil)
@@ -661,6 +2182,25 @@ func BenchmarkProcessGet(b *testing.B) {
 	}
 }
 
+// handlers with an uncommon signature go through reflection, compare with
+// BenchmarkProcessGet
+func BenchmarkProcessGetReflect(b *testing.B) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	s.Get("/echo/(.*)", func(s ...string) string {
+		return s[0]
+	})
+	req := buildTestRequest("GET", "/echo/hi", "", nil, nil)
+	var buf bytes.Buffer
+	iob := ioBuffer{input: nil, output: &buf}
+	c := scgiConn{wroteHeaders: false, req: req, headers: make(map[string][]string), fd: &iob}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Process(&c, req)
+	}
+}
+
 func BenchmarkProcessPost(b *testing.B) {
 	s := NewServer()
 	s.SetLogger(log.New(ioutil.Discard, "", 0))
@@ -677,3 +2217,36 @@ func BenchmarkProcessPost(b *testing.B) {
 		s.Process(&c, req)
 	}
 }
+
+// benchmarkManyRoutes measures dispatching to the last of several hundred
+// routes registered with the given pattern.
+func benchmarkManyRoutes(b *testing.B, pattern string) {
+	s := NewServer()
+	s.SetLogger(log.New(ioutil.Discard, "", 0))
+	for i := 0; i < 300; i++ {
+		s.Get(fmt.Sprintf(pattern, i), func(s string) string {
+			return s
+		})
+	}
+	req := buildTestRequest("GET", "/api/v1/resource299/hi", "", nil, nil)
+	var buf bytes.Buffer
+	iob := ioBuffer{input: nil, output: &buf}
+	c := scgiConn{wroteHeaders: false, req: req, headers: make(map[string][]string), fd: &iob}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Process(&c, req)
+	}
+}
+
+func BenchmarkProcessManyRoutesTree(b *testing.B) {
+	benchmarkManyRoutes(b, "/api/v1/resource%d/:id")
+}
+
+func BenchmarkProcessManyRoutesUnnamed(b *testing.B) {
+	benchmarkManyRoutes(b, "/api/v1/resource%d/([^/]+)")
+}
+
+func BenchmarkProcessManyRoutesRegex(b *testing.B) {
+	benchmarkManyRoutes(b, "/api/v1/resource%d/([a-z]+)")
+}